@@ -17,5 +17,8 @@ func main() {
 
 	app := app.New(cfg, log)
 
-	app.Run()
+	if err := app.Run(); err != nil {
+		log.Error("App stopped with error", "error", err)
+		os.Exit(1)
+	}
 }
\ No newline at end of file
@@ -8,36 +8,92 @@ package app
 // @securityDefinitions.apikey ApiKeyAuth
 // @in header
 // @name Authorization
+// @securityDefinitions.basic BasicAuth
+// @securityDefinitions.apikey AdminAuth
+// @in header
+// @name Authorization
+// @description "Bearer " followed by ADMIN_API_TOKEN
 
 import (
+	"context"
+	"errors"
+	"fmt"
 	"log/slog"
+	"os/signal"
 	"runtime/debug"
+	"syscall"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/adaptor"
 	"github.com/gofiber/fiber/v2/middleware/cors"
 	"github.com/gofiber/fiber/v2/middleware/recover"
+	"github.com/jackc/pgx/v5/pgxpool"
 	_ "github.com/superdumb33/auth-service-test/docs"
 	"github.com/superdumb33/auth-service-test/internal/config"
 	"github.com/superdumb33/auth-service-test/internal/controllers"
+	"github.com/superdumb33/auth-service-test/internal/entities"
 	"github.com/superdumb33/auth-service-test/internal/infrastructure/database"
 	"github.com/superdumb33/auth-service-test/internal/infrastructure/repository/pgxrepo"
 	webhookclient "github.com/superdumb33/auth-service-test/internal/infrastructure/webhook_client"
+	"github.com/superdumb33/auth-service-test/internal/jobs"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
 	"github.com/superdumb33/auth-service-test/internal/services"
+	"github.com/superdumb33/auth-service-test/internal/token"
+	"github.com/superdumb33/auth-service-test/internal/tracing"
+	"github.com/superdumb33/auth-service-test/migrations"
 	fiberSwagger "github.com/swaggo/fiber-swagger"
 )
 
 type App struct {
-	server *fiber.App
-	log    *slog.Logger
-	port   string
+	server          *fiber.App
+	pool            *pgxpool.Pool
+	scheduler       *jobs.Scheduler
+	log             *slog.Logger
+	port            string
+	shutdownTimeout time.Duration
+	//flushes pending spans
+	shutdownTracing func(context.Context) error
 }
 
 func New(cfg config.AppCfg, log *slog.Logger) *App {
+	token.MustInitKeys(token.KeySettings{
+		KeysFile:       cfg.JWTKeysFile,
+		Alg:            cfg.JWTSigningAlg,
+		PrivateKeyPath: cfg.JWTPrivateKeyPath,
+		Secret:         cfg.JWTSecret,
+		KeyID:          cfg.JWTKeyID,
+	})
+	token.SetIssuer(cfg.Issuer)
+	shutdownTracing, err := tracing.Init(context.Background(), cfg.TracesExporter, cfg.ServiceName)
+	if err != nil {
+		panic(err)
+	}
 	pool := database.MustInitNewPool(cfg)
 	authRepo := pgxrepo.NewPgxAuthRepo(pool)
-	httpClient := webhookclient.MustInitNewClient(cfg.WebhookURL, log)
-	authService := services.NewAuthService(authRepo, cfg.AccessTokenTTL, cfg.RefreshTokenTTL, httpClient)
+	clientRepo := pgxrepo.NewPgxClientRepo(pool)
+	authorizationCodeRepo := pgxrepo.NewPgxAuthorizationCodeRepo(pool)
+	sessionLimits := services.SessionLimits{
+		MaxPerUser:  cfg.MaxSessionsPerUser,
+		Policy:      entities.SessionLimitPolicy(cfg.SessionLimitPolicy),
+		MaxLifetime: cfg.SessionMaxLifetime,
+		IdleTimeout: cfg.SessionIdleTimeout,
+	}
+	outboxRepo := pgxrepo.NewPgxOutboxRepo(pool)
+	authService := services.NewAuthService(authRepo, cfg.AccessTokenTTL, cfg.RefreshTokenTTL, sessionLimits, services.NewOutboxPublisher(outboxRepo))
+	clientService := services.NewClientService(clientRepo)
+	authorizationService := services.NewAuthorizationService(authorizationCodeRepo, authService)
 	authController := controllers.NewAuthController(authService)
+	oauthController := controllers.NewOAuthController(authService, authorizationService, clientService, cfg.PublicURL)
+	wellKnownController := controllers.NewWellKnownController(cfg.Issuer, "/api/v"+cfg.ApiVersion,
+		controllers.ClientAuthMethods(cfg.PublicURL))
+	healthService := services.NewHealthService(pgxrepo.NewPgxHealthRepo(pool), migrations.LatestVersion(), cfg.AccessTokenTTL, 2*time.Second)
+	healthController := controllers.NewHealthController(healthService, log)
+	webhookSubscriptionService := services.NewWebhookSubscriptionService(pgxrepo.NewPgxWebhookSubscriptionRepo(pool))
+	mustImportLegacyWebhookURL(log, webhookSubscriptionService, cfg)
+	webhookController := controllers.NewWebhookController(webhookSubscriptionService)
+	deliveryRepo := pgxrepo.NewPgxWebhookDeliveryRepo(pool)
+	webhookDeliveryController := controllers.NewWebhookDeliveryController(services.NewWebhookDeliveryService(deliveryRepo, outboxRepo))
 
 	server := fiber.New(fiber.Config{
 		ErrorHandler: controllers.ErrHandler,
@@ -55,15 +111,136 @@ func New(cfg config.AppCfg, log *slog.Logger) *App {
 			)
 		},
 	}))
+	//registered before the logging handler, so frequent probes and scrapes neither flood the log nor skew HTTP metrics
+	healthController.RegisterRoutes(server)
+	server.Get("/metrics", adaptor.HTTPHandler(metrics.Handler()))
+	server.Use(controllers.TracingHandler())
 	server.Use(controllers.LoggingHandler(log))
+	wellKnownController.RegisterRoutes(server)
 	apiRouter := server.Group("/api/v" + cfg.ApiVersion)
-	authController.RegisterRoutes(apiRouter, controllers.AuthMiddleware(authRepo))
+	authController.RegisterRoutes(apiRouter, controllers.AuthMiddleware(authRepo, authService), controllers.ClientAuthMiddleware(clientService, cfg.PublicURL))
+	oauthController.RegisterRoutes(apiRouter, controllers.AuthMiddleware(authRepo, authService))
+	if cfg.AdminAPIToken != "" {
+		adminMiddleware := controllers.AdminMiddleware(cfg.AdminAPIToken)
+		webhookController.RegisterRoutes(apiRouter, adminMiddleware)
+		webhookDeliveryController.RegisterRoutes(apiRouter, adminMiddleware)
+	}
+
+	scheduler := jobs.NewScheduler(log)
+	if cfg.PurgeInterval > 0 {
+		purgeService := services.NewPurgeService(pgxrepo.NewPgxPurgeRepo(pool), cfg.PurgeRetention, cfg.WebhookDeliveryRetention,
+			cfg.PurgeBatchSize)
+		scheduler.Add("purge", cfg.PurgeInterval, func(ctx context.Context) error {
+			result, err := purgeService.Purge(ctx)
+			log.Info("expired rows purged", "sessions", result.Sessions, "authorization_codes", result.AuthorizationCodes,
+				"client_assertions", result.ClientAssertions, "webhooks", result.Webhooks,
+				"webhook_deliveries", result.WebhookDeliveries)
+			return err
+		})
+	}
+	outboxDispatcher := services.NewOutboxDispatcher(outboxRepo, webhookclient.NewClient(log, cfg.Issuer), deliveryRepo, services.RetryPolicy{
+		MaxAttempts: cfg.WebhookMaxAttempts,
+		BaseDelay:   cfg.WebhookRetryBaseDelay,
+		MaxDelay:    cfg.WebhookRetryMaxDelay,
+	}, 20, webhookclient.Timeout)
+	scheduler.Add("webhook_outbox", cfg.WebhookDispatchInterval, func(ctx context.Context) error {
+		result, err := outboxDispatcher.Dispatch(ctx)
+		if result.Retried > 0 || result.Dead > 0 {
+			log.Warn("webhook deliveries failed", "delivered", result.Delivered, "retried", result.Retried, "dead", result.Dead)
+		}
+		return err
+	})
+	scheduler.Start()
 
-	return &App{server: server, log: log, port: cfg.AppPort}
+	return &App{
+		server:          server,
+		pool:            pool,
+		scheduler:       scheduler,
+		log:             log,
+		port:            cfg.AppPort,
+		shutdownTimeout: cfg.ShutdownTimeout,
+		shutdownTracing: shutdownTracing,
+	}
 }
 
+// serves requests until SIGINT or SIGTERM is received or the server fails, then shuts the app down
 func (app *App) Run() error {
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
 	app.log.Info("Starting server", "port", app.port)
+	listenErr := make(chan error, 1)
+	go func() {
+		listenErr <- app.server.Listen(":" + app.port)
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		app.log.Info("Shutdown signal received")
+	case err = <-listenErr:
+		app.log.Error("Server failed", "error", err)
+	}
+	//second signal kills the app right away
+	stop()
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), app.shutdownTimeout)
+	defer cancel()
+
+	return errors.Join(err, app.Shutdown(shutdownCtx))
+}
+
+// stops accepting requests and waits for in-flight ones and background jobs, webhook delivery included, then closes the pool;
+// whatever isn't finished when ctx is done is abandoned
+func (app *App) Shutdown(ctx context.Context) error {
+	const op = "app:Shutdown"
+	var errs []error
+	if err := app.server.ShutdownWithContext(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("%s:server:%w", op, err))
+	}
+	if err := app.scheduler.Stop(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("%s:jobs:%w", op, err))
+	}
+	//pool.Close blocks until every connection is released, so it's skipped if something is still running
+	if ctx.Err() == nil {
+		app.pool.Close()
+	}
+	//spans of everything above are exported last
+	if err := app.shutdownTracing(ctx); err != nil {
+		errs = append(errs, fmt.Errorf("%s:tracing:%w", op, err))
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return err
+	}
+	app.log.Info("Shutdown complete")
+
+	return nil
+}
+
+// webhooks used to go to WEBHOOK_URL; it's turned into a subscription, so upgrading doesn't stop them.
+// it'll throw a panic if the subscription can't be created
+func mustImportLegacyWebhookURL(log *slog.Logger, service *services.WebhookSubscriptionService, cfg config.AppCfg) {
+	ctx := context.Background()
+	if cfg.WebhookURL == "" {
+		waiting, err := service.CountUnsubscribed(ctx)
+		if err != nil {
+			panic(err)
+		}
+		if waiting > 0 {
+			log.Warn("webhooks queued for WEBHOOK_URL wait for it to be set along with WEBHOOK_SECRET", "webhooks", waiting)
+		}
+		return
+	}
 
-	return app.server.Listen(":" + app.port)
+	sub, created, attached, err := service.ImportLegacyURL(ctx, cfg.WebhookURL, cfg.WebhookSecret)
+	if err != nil {
+		panic(err)
+	}
+	if created || attached > 0 {
+		log.Info("WEBHOOK_URL imported as webhook subscription", "subscription_id", sub.ID, "created", created,
+			"attached_webhooks", attached)
+	}
+	log.Warn("WEBHOOK_URL and WEBHOOK_SECRET are deprecated; manage the subscription with the admin API and unset them",
+		"subscription_id", sub.ID)
 }
@@ -2,6 +2,8 @@ package config
 
 import (
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/joho/godotenv"
@@ -14,11 +16,55 @@ type AppCfg struct {
 	PostgresHost     string
 	PostgresPort     string
 	JWTSecret        string
-	AppPort          string
-	ApiVersion       string
-	AccessTokenTTL   time.Duration
-	RefreshTokenTTL  time.Duration
-	WebhookURL       string
+	//HS512 by default; RS*, PS*, ES* and EdDSA read PEM private key from JWTPrivateKeyPath
+	JWTSigningAlg     string
+	JWTPrivateKeyPath string
+	//kid of the key above; RFC 7638 thumbprint is used if empty
+	JWTKeyID string
+	//JSON key ring for rotation; replaces the single key settings above when set
+	JWTKeysFile string
+	AppPort     string
+	//externally visible base URL of the service, e.g. https://auth.example.com; if empty, the issuer is http://localhost:AppPort
+	//and client assertions are refused
+	PublicURL string
+	//iss of tokens and OpenID Connect issuer; PublicURL or http://localhost:AppPort
+	Issuer          string
+	ApiVersion      string
+	AccessTokenTTL  time.Duration
+	RefreshTokenTTL time.Duration
+	//0 means unlimited
+	MaxSessionsPerUser int
+	//reject, evict_oldest or evict_lru; evict_oldest by default
+	SessionLimitPolicy string
+	//absolute session lifetime counted from the login; 0 means unlimited
+	SessionMaxLifetime time.Duration
+	//session expires after that long without use; 0 means unlimited
+	SessionIdleTimeout time.Duration
+	//how often expired sessions, authorization codes and client assertions are purged; 1h by default, 0 disables purging
+	PurgeInterval time.Duration
+	//expired rows are kept for that long before they're purged; 24h by default
+	PurgeRetention time.Duration
+	//rows deleted by a single statement; 1000 by default
+	PurgeBatchSize int
+	//deprecated, webhooks go to subscriptions; if set, a subscription to every event is created from them on startup
+	WebhookURL    string
+	WebhookSecret string
+	//how often the webhook outbox is checked for due messages; 1s by default
+	WebhookDispatchInterval time.Duration
+	//webhook is given up after that many failed attempts; 10 by default
+	WebhookMaxAttempts int
+	//delay after the first failed attempt, doubled after every next one up to WebhookRetryMaxDelay; 10s and 1h by default
+	WebhookRetryBaseDelay time.Duration
+	WebhookRetryMaxDelay  time.Duration
+	//webhook delivery attempts are kept for that long before they're purged; 720h by default
+	WebhookDeliveryRetention time.Duration
+	//time given to in-flight requests, webhooks and background jobs to finish on shutdown; 15s by default
+	ShutdownTimeout time.Duration
+	//bearer token of the admin API; the API is off if empty
+	AdminAPIToken string
+	//otlp, stdout or none; none by default
+	TracesExporter string
+	ServiceName    string
 }
 
 // it'll throw a panic if something goes wrong
@@ -33,18 +79,163 @@ func MustInit() AppCfg {
 	if err != nil {
 		panic(err)
 	}
+	signingAlg := os.Getenv("JWT_SIGNING_ALG")
+	if signingAlg == "" {
+		signingAlg = "HS512"
+	}
+	maxSessions := 0
+	if raw := os.Getenv("MAX_SESSIONS_PER_USER"); raw != "" {
+		maxSessions, err = strconv.Atoi(raw)
+		if err != nil {
+			panic(err)
+		}
+	}
+	sessionMaxLifetime, err := optionalDuration("SESSION_MAX_LIFETIME")
+	if err != nil {
+		panic(err)
+	}
+	sessionIdleTimeout, err := optionalDuration("SESSION_IDLE_TIMEOUT")
+	if err != nil {
+		panic(err)
+	}
+	purgeInterval, err := durationOrDefault("PURGE_INTERVAL", time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	purgeRetention, err := durationOrDefault("PURGE_RETENTION", 24*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	purgeBatchSize, err := intOrDefault("PURGE_BATCH_SIZE", 1000)
+	if err != nil {
+		panic(err)
+	}
+	if purgeBatchSize <= 0 {
+		panic("PURGE_BATCH_SIZE must be positive")
+	}
+	shutdownTimeout, err := durationOrDefault("SHUTDOWN_TIMEOUT", 15*time.Second)
+	if err != nil {
+		panic(err)
+	}
+	webhookDispatchInterval, err := durationOrDefault("WEBHOOK_DISPATCH_INTERVAL", time.Second)
+	if err != nil {
+		panic(err)
+	}
+	if webhookDispatchInterval <= 0 {
+		panic("WEBHOOK_DISPATCH_INTERVAL must be positive")
+	}
+	webhookMaxAttempts, err := intOrDefault("WEBHOOK_MAX_ATTEMPTS", 10)
+	if err != nil {
+		panic(err)
+	}
+	if webhookMaxAttempts <= 0 {
+		panic("WEBHOOK_MAX_ATTEMPTS must be positive")
+	}
+	webhookRetryBaseDelay, err := durationOrDefault("WEBHOOK_RETRY_BASE_DELAY", 10*time.Second)
+	if err != nil {
+		panic(err)
+	}
+	webhookRetryMaxDelay, err := durationOrDefault("WEBHOOK_RETRY_MAX_DELAY", time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	webhookDeliveryRetention, err := durationOrDefault("WEBHOOK_DELIVERY_RETENTION", 720*time.Hour)
+	if err != nil {
+		panic(err)
+	}
+	if os.Getenv("WEBHOOK_URL") != "" && os.Getenv("WEBHOOK_SECRET") == "" {
+		panic("WEBHOOK_SECRET must be set along with WEBHOOK_URL")
+	}
+	tracesExporter := os.Getenv("OTEL_TRACES_EXPORTER")
+	switch tracesExporter {
+	case "":
+		tracesExporter = "none"
+	case "otlp", "stdout", "none":
+	default:
+		panic("unknown OTEL_TRACES_EXPORTER: " + tracesExporter)
+	}
+	serviceName := os.Getenv("OTEL_SERVICE_NAME")
+	if serviceName == "" {
+		serviceName = "auth-service"
+	}
+	sessionLimitPolicy := os.Getenv("SESSION_LIMIT_POLICY")
+	switch sessionLimitPolicy {
+	case "":
+		sessionLimitPolicy = "evict_oldest"
+	case "reject", "evict_oldest", "evict_lru":
+	default:
+		panic("unknown SESSION_LIMIT_POLICY: " + sessionLimitPolicy)
+	}
+	publicURL := strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	issuer := publicURL
+	if issuer == "" {
+		issuer = "http://localhost:" + os.Getenv("APP_PORT")
+	}
 
 	return AppCfg{
-		PostgresUser:     os.Getenv("POSTGRES_USER"),
-		PostgresDB:       os.Getenv("POSTGRES_DB"),
-		PostgresPassword: os.Getenv("POSTGRES_PASSWORD"),
-		PostgresHost:     os.Getenv("POSTGRES_HOST"),
-		PostgresPort:     os.Getenv("POSTGRES_PORT"),
-		JWTSecret:        os.Getenv("JWT_SECRET"),
-		AppPort:          os.Getenv("APP_PORT"),
-		ApiVersion:       os.Getenv("API_VERSION"),
-		AccessTokenTTL:   accessTTL,
-		RefreshTokenTTL:  refreshTTL,
-		WebhookURL:       os.Getenv("WEBHOOK_URL"),
+		PostgresUser:             os.Getenv("POSTGRES_USER"),
+		PostgresDB:               os.Getenv("POSTGRES_DB"),
+		PostgresPassword:         os.Getenv("POSTGRES_PASSWORD"),
+		PostgresHost:             os.Getenv("POSTGRES_HOST"),
+		PostgresPort:             os.Getenv("POSTGRES_PORT"),
+		JWTSecret:                os.Getenv("JWT_SECRET"),
+		JWTSigningAlg:            signingAlg,
+		JWTPrivateKeyPath:        os.Getenv("JWT_PRIVATE_KEY_PATH"),
+		JWTKeyID:                 os.Getenv("JWT_KEY_ID"),
+		JWTKeysFile:              os.Getenv("JWT_KEYS_FILE"),
+		AppPort:                  os.Getenv("APP_PORT"),
+		PublicURL:                publicURL,
+		Issuer:                   issuer,
+		ApiVersion:               os.Getenv("API_VERSION"),
+		AccessTokenTTL:           accessTTL,
+		RefreshTokenTTL:          refreshTTL,
+		MaxSessionsPerUser:       maxSessions,
+		SessionLimitPolicy:       sessionLimitPolicy,
+		SessionMaxLifetime:       sessionMaxLifetime,
+		SessionIdleTimeout:       sessionIdleTimeout,
+		PurgeInterval:            purgeInterval,
+		PurgeRetention:           purgeRetention,
+		PurgeBatchSize:           purgeBatchSize,
+		WebhookURL:               os.Getenv("WEBHOOK_URL"),
+		WebhookSecret:            os.Getenv("WEBHOOK_SECRET"),
+		WebhookDispatchInterval:  webhookDispatchInterval,
+		WebhookMaxAttempts:       webhookMaxAttempts,
+		WebhookRetryBaseDelay:    webhookRetryBaseDelay,
+		WebhookRetryMaxDelay:     webhookRetryMaxDelay,
+		WebhookDeliveryRetention: webhookDeliveryRetention,
+		ShutdownTimeout:          shutdownTimeout,
+		AdminAPIToken:            os.Getenv("ADMIN_API_TOKEN"),
+		TracesExporter:           tracesExporter,
+		ServiceName:              serviceName,
+	}
+}
+
+// returns 0 if env variable is empty
+func optionalDuration(key string) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return 0, nil
 	}
+
+	return time.ParseDuration(raw)
+}
+
+// returns def if env variable is empty
+func durationOrDefault(key string, def time.Duration) (time.Duration, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+
+	return time.ParseDuration(raw)
+}
+
+// returns def if env variable is empty
+func intOrDefault(key string, def int) (int, error) {
+	raw := os.Getenv(key)
+	if raw == "" {
+		return def, nil
+	}
+
+	return strconv.Atoi(raw)
 }
@@ -0,0 +1,25 @@
+package controllers
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// lets through requests bearing adminToken; panics if it's empty, as an empty bearer token would pass
+func AdminMiddleware(adminToken string) fiber.Handler {
+	if adminToken == "" {
+		panic("empty admin token")
+	}
+	return func(c *fiber.Ctx) error {
+		const op = "adminmiddleware:"
+		token, found := strings.CutPrefix(c.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
+		}
+
+		return c.Next()
+	}
+}
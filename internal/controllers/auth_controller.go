@@ -2,6 +2,7 @@ package controllers
 
 import (
 	"fmt"
+	"strconv"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/google/uuid"
@@ -12,6 +13,7 @@ import (
 
 var (
 	ErrBadRequest = entities.ErrBadRequest
+	ErrForbidden  = entities.ErrForbidden
 )
 
 type AuthController struct {
@@ -22,24 +24,33 @@ func NewAuthController(service *services.AuthService) *AuthController {
 	return &AuthController{service: service}
 }
 
-func (ac *AuthController) RegisterRoutes(router fiber.Router, authMiddleware fiber.Handler) {
+func (ac *AuthController) RegisterRoutes(router fiber.Router, authMiddleware, clientAuthMiddleware fiber.Handler) {
 	authRouter := router.Group("/auth")
-	authRouter.Post("/issue", ac.Issue)
+	authRouter.Post("/issue", clientAuthMiddleware, ac.Issue)
 	authRouter.Post("/refresh", ac.Refresh)
 
 	authRouterProtected := router.Group("/auth", authMiddleware)
 	authRouterProtected.Get("/me", ac.GetCurrentUserID)
 	authRouterProtected.Post("/logout", ac.Logout)
+	authRouterProtected.Get("/sessions", ac.ListSessions)
+	authRouterProtected.Post("/sessions/revoke-all", ac.RevokeAllTokens)
+	authRouterProtected.Delete("/sessions/:id", ac.RevokeSession)
 }
 
 
 // @Summary   Issue tokens
+// @Description Requires client credentials: HTTP Basic auth with client ID and secret, or a signed JWT client assertion (RFC 7523)
 // @Tags      auth
-// @Accept    json
+// @Security  BasicAuth
+// @Accept    x-www-form-urlencoded
 // @Produce   json
-// @Param     user_id   query     string  true  "User GUID"
+// @Param     user_id                query     string  true   "User GUID"
+// @Param     client_assertion_type  formData  string  false  "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param     client_assertion       formData  string  false  "JWT signed with client's private key"
 // @Success   200       {object}  dto.IssueTokensResponse
 // @Failure   400       {object}  dto.FailureResponse
+// @Failure   401       {object}  dto.FailureResponse
+// @Failure   403       {object}  dto.FailureResponse
 // @Failure   500       {object}  dto.FailureResponse
 // @Router    /auth/issue [post]
 func (ac *AuthController) Issue(c *fiber.Ctx) error {
@@ -48,6 +59,10 @@ func (ac *AuthController) Issue(c *fiber.Ctx) error {
 	if err != nil {
 		return fmt.Errorf("%s:%w", op, ErrBadRequest)
 	}
+	client := c.Locals("client").(*entities.Client)
+	if !client.AllowsUser(userID) {
+		return fmt.Errorf("%s:%w", op, ErrForbidden)
+	}
 
 	userAgent := c.Get("User-Agent")
 	if userAgent == "" {
@@ -55,7 +70,7 @@ func (ac *AuthController) Issue(c *fiber.Ctx) error {
 	}
 	ip := c.IP()
 
-	tokens, err := ac.service.GenerateTokens(c.Context(), userID, ip, userAgent)
+	tokens, err := ac.service.GenerateTokens(c.UserContext(), client.ID, userID, "", ip, userAgent)
 	if err != nil {
 		return err
 	}
@@ -93,7 +108,7 @@ func (ac *AuthController) Refresh(c *fiber.Ctx) error {
 		return fmt.Errorf("%s:%w", op, ErrBadRequest)
 	}
 
-	tokens, err := ac.service.Refresh(c.Context(), request.AccessToken, request.RefreshToken, c.IP(), userAgent)
+	tokens, err := ac.service.Refresh(c.UserContext(), request.AccessToken, request.RefreshToken, c.IP(), userAgent)
 	if err != nil {
 		return err
 	}
@@ -131,20 +146,133 @@ func (ac *AuthController) GetCurrentUserID(c *fiber.Ctx) error {
 func (ac *AuthController) Logout(c *fiber.Ctx) error {
 	//const op = "controller:logout"
 	jti := c.Locals("jti").(uuid.UUID)
+	userID := c.Locals("userid").(uuid.UUID)
 
-	if err := ac.service.Logout(c.Context(), jti); err != nil {
+	if err := ac.service.Logout(c.UserContext(), userID, jti); err != nil {
 		return err
 	}
 
 	return c.SendStatus(204)
 }
 
-//can be used to revoke all tokens; for example - after password change
+// @Summary   List active sessions of current user
+// @Tags      auth
+// @Security  ApiKeyAuth
+// @Produce   json
+// @Param     limit   query  int     false  "Page size, 1 to 100"  default(20)
+// @Param     offset  query  int     false  "Number of sessions to skip"  default(0)
+// @Param     sort    query  string  false  "issued_at or expires_at"  default(issued_at)
+// @Param     order   query  string  false  "asc or desc"  default(desc)
+// @Success   200  {object}  dto.ListSessionsResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   500  {object}  dto.FailureResponse
+// @Router    /auth/sessions [get]
+func (ac *AuthController) ListSessions(c *fiber.Ctx) error {
+	const op = "controller:ListSessions"
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+	offset, err := strconv.Atoi(c.Query("offset", "0"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+	order := c.Query("order", "desc")
+	if order != "asc" && order != "desc" {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+	params := entities.SessionListParams{
+		Limit:  limit,
+		Offset: offset,
+		SortBy: c.Query("sort", entities.SessionSortIssuedAt),
+		Desc:   order == "desc",
+	}
+	userID := c.Locals("userid").(uuid.UUID)
+	currentID := c.Locals("jti").(uuid.UUID)
+
+	sessions, total, err := ac.service.ListSessions(c.UserContext(), userID, params)
+	if err != nil {
+		return err
+	}
+
+	resp := &dto.ListSessionsResponse{
+		Sessions: make([]dto.SessionResponse, 0, len(sessions)),
+		Total:    total,
+		Limit:    limit,
+		Offset:   offset,
+	}
+	for _, session := range sessions {
+		item := dto.SessionResponse{
+			ID:         session.ID.String(),
+			IssuedAt:   session.IssuedAt,
+			ExpiresAt:  session.ExpiresAt,
+			UserAgent:  session.UserAgent,
+			IPAddress:  session.IPAddress,
+			AuthTime:   session.AuthTime,
+			LastUsedAt: session.LastUsedAt,
+			IsCurrent:  session.ID == currentID,
+		}
+		absolute, idle := ac.service.SessionDeadlines(session)
+		if !absolute.IsZero() {
+			item.AbsoluteExpiresAt = &absolute
+		}
+		if !idle.IsZero() {
+			item.IdleExpiresAt = &idle
+		}
+		resp.Sessions = append(resp.Sessions, item)
+	}
+
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary   Revoke all sessions of current user
+// @Description Can be used to revoke all tokens; for example - after password change or when a device is lost
+// @Tags      auth
+// @Security  ApiKeyAuth
+// @Param     keep_current  query  bool  false  "Keep the session of the access token used for this request"
+// @Success   204
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Router    /auth/sessions/revoke-all [post]
 func (ac *AuthController) RevokeAllTokens(c *fiber.Ctx) error {
-	//const op = "controller:RevokeAllTokens"
+	const op = "controller:RevokeAllTokens"
+	userID := c.Locals("userid").(uuid.UUID)
+	keepCurrent, err := strconv.ParseBool(c.Query("keep_current", "false"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+
+	if keepCurrent {
+		err = ac.service.RevokeOtherSessions(c.UserContext(), userID, c.Locals("jti").(uuid.UUID))
+	} else {
+		err = ac.service.RevokeAllByUserID(c.UserContext(), userID, c.Locals("jti").(uuid.UUID))
+	}
+	if err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
+
+// @Summary   Revoke session of current user
+// @Tags      auth
+// @Security  ApiKeyAuth
+// @Param     id   path  string  true  "Session ID"
+// @Success   204
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   404  {object}  dto.FailureResponse
+// @Router    /auth/sessions/{id} [delete]
+func (ac *AuthController) RevokeSession(c *fiber.Ctx) error {
+	const op = "controller:RevokeSession"
+	sessionID, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
 	userID := c.Locals("userid").(uuid.UUID)
 
-	if err := ac.service.RevokeAllByUserID(c.Context(), userID); err != nil {
+	if err := ac.service.RevokeSession(c.UserContext(), userID, sessionID); err != nil {
 		return err
 	}
 
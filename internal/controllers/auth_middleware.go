@@ -1,13 +1,16 @@
 package controllers
 
 import (
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/gofiber/fiber/v2"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
 	"github.com/superdumb33/auth-service-test/internal/services"
 	"github.com/superdumb33/auth-service-test/internal/token"
 )
@@ -17,48 +20,76 @@ var (
 	ErrExpired = entities.ErrExpired
 )
 
-func AuthMiddleware(repo services.AuthRepo) fiber.Handler {
+// authService revokes sessions used with another User-Agent
+func AuthMiddleware(repo services.AuthRepo, authService *services.AuthService) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		const op = "authmiddleware:"
 		tokenString := strings.TrimPrefix(c.Get("Authorization"), "Bearer ")
 		if tokenString == "" {
+			metrics.AccessTokenRejections.WithLabelValues("missing_token").Inc()
 			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
 		}
 
-		token, err := token.ParseJWTToken(tokenString, false)
+		token, err := token.ParseAccessToken(tokenString, false)
 		if err != nil || !token.Valid {
 			if err == jwt.ErrTokenExpired {
+				metrics.AccessTokenRejections.WithLabelValues("expired").Inc()
 				return fmt.Errorf("%s:%w", op, ErrExpired)
 			}
+			metrics.AccessTokenRejections.WithLabelValues("invalid_token").Inc()
 			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
 		}
 
 		claims := token.Claims.(jwt.MapClaims)
-		jtiString := claims["jti"].(string)
+		jtiString, ok := claims["jti"].(string)
+		if !ok {
+			metrics.AccessTokenRejections.WithLabelValues("invalid_token").Inc()
+			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
+		}
 		jti, err := uuid.Parse(jtiString)
 		if err != nil {
+			metrics.AccessTokenRejections.WithLabelValues("invalid_token").Inc()
 			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
 		}
 
-		session, err := repo.GetTokenByID(c.Context(), jti)
+		session, err := repo.GetTokenByID(c.UserContext(), jti)
 		if err != nil {
+			if errors.Is(err, entities.ErrNotFound) {
+				metrics.AccessTokenRejections.WithLabelValues("unknown_session").Inc()
+			}
 			return err
 		}
 
 		if session == nil || session.Revoked {
+			metrics.AccessTokenRejections.WithLabelValues("revoked").Inc()
+			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
+		}
+
+		//session past its deadline isn't touched, or a request would bring it back within the idle timeout
+		if authService.SessionExpiryReason(session) != "" {
+			metrics.AccessTokenRejections.WithLabelValues("session_expired").Inc()
 			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
 		}
 
 		if session.UserAgent != c.Get("User-Agent") {
-			if err := repo.Revoke(c.Context(), session.ID); err != nil {
+			if err := authService.RevokeUserAgentMismatch(c.UserContext(), session, c.IP(), c.Get("User-Agent")); err != nil {
 				return err
 			}
+			metrics.AccessTokenRejections.WithLabelValues("ua_mismatch").Inc()
 
 			return fmt.Errorf("%s:%w", op, ErrUnauthorized)
 		}
 
+		if time.Since(session.LastUsedAt) > entities.SessionTouchInterval {
+			if err := repo.Touch(c.UserContext(), session.ID); err != nil {
+				return err
+			}
+		}
+
 		c.Locals("userid", session.UserID)
 		c.Locals("jti", session.ID)
+		c.Locals("authtime", session.AuthTime)
+		c.Locals("scope", session.Scope)
 
 		return c.Next()
 
@@ -0,0 +1,120 @@
+package controllers_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/controllers"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+func TestAuthMiddleware_RejectsIDToken(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKCS8PrivateKey(privateKey)
+	signingKey, err := token.ParseSigningKeyPEM(jwt.SigningMethodES256, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+	idToken, err := token.GenerateIDToken(token.IDTokenClaims{Subject: "user", Audience: "spa", AuthTime: time.Now(), SessionID: "session"}, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	//ID token is rejected before the session is looked up, so no repo is needed
+	app := fiber.New(fiber.Config{ErrorHandler: controllers.ErrHandler})
+	app.Get("/me", controllers.AuthMiddleware(nil, nil), func(c *fiber.Ctx) error {
+		return c.SendStatus(http.StatusOK)
+	})
+	req := httptest.NewRequest(http.MethodGet, "/me", nil)
+	req.Header.Set("Authorization", "Bearer "+idToken)
+	resp, err := app.Test(req)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("expected 401, got %d", resp.StatusCode)
+	}
+}
+
+// holds sessions by ID and records touched ones; the rest of AuthRepo isn't used by the middleware
+type MockAuthRepo struct {
+	services.AuthRepo
+	Sessions map[uuid.UUID]*entities.RefreshToken
+	Touched  []uuid.UUID
+}
+
+func (mr *MockAuthRepo) GetTokenByID(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error) {
+	session, ok := mr.Sessions[id]
+	if !ok {
+		return nil, entities.ErrNotFound
+	}
+	return session, nil
+}
+
+func (mr *MockAuthRepo) Touch(ctx context.Context, id uuid.UUID) error {
+	mr.Touched = append(mr.Touched, id)
+	return nil
+}
+
+func TestAuthMiddleware_SessionDeadlines(t *testing.T) {
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+	limits := services.SessionLimits{MaxLifetime: 24 * time.Hour, IdleTimeout: time.Hour}
+
+	cases := map[string]struct {
+		authTime, lastUsedAt time.Time
+		status               int
+	}{
+		"active":            {authTime: time.Now().Add(-time.Hour), lastUsedAt: time.Now().Add(-30 * time.Minute), status: http.StatusOK},
+		"idle":              {authTime: time.Now().Add(-3 * time.Hour), lastUsedAt: time.Now().Add(-2 * time.Hour), status: http.StatusUnauthorized},
+		"past max lifetime": {authTime: time.Now().Add(-25 * time.Hour), lastUsedAt: time.Now().Add(-30 * time.Minute), status: http.StatusUnauthorized},
+	}
+	for name, tc := range cases {
+		t.Run(name, func(t *testing.T) {
+			session := &entities.RefreshToken{ID: uuid.New(), UserID: uuid.New(), AuthTime: tc.authTime, LastUsedAt: tc.lastUsedAt,
+				ExpiresAt: time.Now().Add(time.Hour), UserAgent: "test-agent"}
+			repo := &MockAuthRepo{Sessions: map[uuid.UUID]*entities.RefreshToken{session.ID: session}}
+			authService := services.NewAuthService(repo, 5*time.Minute, time.Hour, limits, nil)
+			accessToken, err := token.GenerateAccessToken(session.ID.String(), 5*time.Minute)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			app := fiber.New(fiber.Config{ErrorHandler: controllers.ErrHandler})
+			app.Get("/me", controllers.AuthMiddleware(repo, authService), func(c *fiber.Ctx) error {
+				return c.SendStatus(http.StatusOK)
+			})
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+accessToken)
+			req.Header.Set("User-Agent", "test-agent")
+			resp, err := app.Test(req)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if resp.StatusCode != tc.status {
+				t.Fatalf("expected %d, got %d", tc.status, resp.StatusCode)
+			}
+			//request to a session past its deadline doesn't count as its use
+			if touched := len(repo.Touched) > 0; touched != (tc.status == http.StatusOK) {
+				t.Fatalf("unexpected touches: %v", repo.Touched)
+			}
+		})
+	}
+}
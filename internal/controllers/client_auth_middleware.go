@@ -0,0 +1,94 @@
+package controllers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+const clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+
+// request carries no client credentials at all; public clients are identified by client_id alone then
+var errNoClientCredentials = fmt.Errorf("%w: no client credentials", ErrUnauthorized)
+
+// authenticates calling client with HTTP Basic auth, JWT client assertion or client_id and client_secret form fields;
+// sets "client" local. Assertions are refused if publicURL is empty, as their audience would come from the Host header
+func ClientAuthMiddleware(service *services.ClientService, publicURL string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		const op = "clientauthmiddleware:"
+		client, err := authenticateClient(c, service, publicURL)
+		if err != nil {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth"`)
+			return fmt.Errorf("%s:%w", op, err)
+		}
+
+		c.Locals("client", client)
+
+		return c.Next()
+	}
+}
+
+func authenticateClient(c *fiber.Ctx, service *services.ClientService, publicURL string) (*entities.Client, error) {
+	if basic, ok := strings.CutPrefix(c.Get(fiber.HeaderAuthorization), "Basic "); ok {
+		clientID, secret, err := parseBasicCredentials(basic)
+		if err != nil {
+			return nil, ErrUnauthorized
+		}
+		return service.AuthenticateSecret(c.UserContext(), clientID, secret)
+	}
+
+	if c.FormValue("client_assertion_type") == clientAssertionType && c.FormValue("client_assertion") != "" {
+		if publicURL == "" {
+			return nil, fmt.Errorf("%w: client assertions need PUBLIC_URL", ErrUnauthorized)
+		}
+		return service.AuthenticateAssertion(c.UserContext(), c.FormValue("client_assertion"), assertionAudiences(c, publicURL))
+	}
+
+	//client_secret_post
+	if c.FormValue("client_id") != "" && c.FormValue("client_secret") != "" {
+		return service.AuthenticateSecret(c.UserContext(), c.FormValue("client_id"), c.FormValue("client_secret"))
+	}
+
+	return nil, errNoClientCredentials
+}
+
+// client ID and secret are form-urlencoded before being joined (RFC 6749 section 2.3.1)
+func parseBasicCredentials(encoded string) (string, string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", err
+	}
+	rawID, rawSecret, ok := strings.Cut(string(decoded), ":")
+	if !ok {
+		return "", "", ErrBadRequest
+	}
+	clientID, err := url.QueryUnescape(rawID)
+	if err != nil {
+		return "", "", err
+	}
+	secret, err := url.QueryUnescape(rawSecret)
+	if err != nil {
+		return "", "", err
+	}
+
+	return clientID, secret, nil
+}
+
+// assertion may be addressed either to the endpoint it's sent to or to the service as a whole
+func assertionAudiences(c *fiber.Ctx, publicURL string) []string {
+	return []string{publicURL + c.Path(), publicURL}
+}
+
+// token endpoint auth methods ClientAuthMiddleware accepts with the given public URL
+func ClientAuthMethods(publicURL string) []string {
+	if publicURL == "" {
+		return []string{"client_secret_basic", "client_secret_post", "none"}
+	}
+
+	return []string{"client_secret_basic", "client_secret_post", "private_key_jwt", "none"}
+}
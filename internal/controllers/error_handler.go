@@ -5,25 +5,38 @@ import (
 	"net/http"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/superdumb33/auth-service-test/internal/dto"
 	"github.com/superdumb33/auth-service-test/internal/entities"
 )
 
 //maps error to HTTP code by comapring err with a sentinel entities package errors, 
 func ErrHandler(c *fiber.Ctx, err error) error {
+	var oauthErr *OAuthError
+	if errors.As(err, &oauthErr) {
+		if oauthErr.Status == http.StatusUnauthorized {
+			c.Set(fiber.HeaderWWWAuthenticate, `Basic realm="auth"`)
+		}
+		return c.Status(oauthErr.Status).
+			JSON(dto.OAuthErrorResponse{Error: oauthErr.Code, ErrorDescription: oauthErr.Description})
+	}
+
 	switch {
-	case errors.Is(err, entities.ErrBadRequest):
+	case errors.Is(err, entities.ErrBadRequest), errors.Is(err, entities.ErrInvalidScope):
 		return c.Status(http.StatusBadRequest).
 			JSON(fiber.Map{"error": http.StatusText(http.StatusBadRequest)})
+	case errors.Is(err, entities.ErrForbidden), errors.Is(err, entities.ErrSessionLimit):
+		return c.Status(http.StatusForbidden).
+			JSON(fiber.Map{"error": http.StatusText(http.StatusForbidden)})
 	case errors.Is(err, entities.ErrNotFound):
 		return c.Status(http.StatusNotFound).
 			JSON(fiber.Map{"error": http.StatusText(http.StatusNotFound)})
-	case errors.Is(err, entities.ErrDuplicate):
+	case errors.Is(err, entities.ErrDuplicate), errors.Is(err, entities.ErrConflict):
 		return c.Status(http.StatusConflict).
 			JSON(fiber.Map{"error": http.StatusText(http.StatusConflict)})
 	case errors.Is(err, entities.ErrExpired):
 		return c.Status(http.StatusUnauthorized).
 			JSON(fiber.Map{"error": http.StatusText(http.StatusUnauthorized)})
-	case errors.Is(err, entities.ErrUnauthorized), errors.Is(err, entities.ErrExpired), errors.Is(err, entities.ErrRevoked):
+	case errors.Is(err, entities.ErrUnauthorized), errors.Is(err, entities.ErrRevoked), errors.Is(err, entities.ErrReused):
 		return c.Status(http.StatusUnauthorized).
 			JSON(fiber.Map{"error": http.StatusText(http.StatusUnauthorized)})
 	default:
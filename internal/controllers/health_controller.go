@@ -0,0 +1,59 @@
+package controllers
+
+import (
+	"log/slog"
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/superdumb33/auth-service-test/internal/dto"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+const (
+	healthStatusOK          = "ok"
+	healthStatusUnavailable = "unavailable"
+)
+
+// serves liveness and readiness probes; routes are registered on the server root, outside of the versioned API
+type HealthController struct {
+	healthService *services.HealthService
+	log           *slog.Logger
+}
+
+func NewHealthController(healthService *services.HealthService, log *slog.Logger) *HealthController {
+	return &HealthController{healthService: healthService, log: log}
+}
+
+func (hc *HealthController) RegisterRoutes(router fiber.Router) {
+	router.Get("/healthz", hc.Healthz)
+	router.Get("/readyz", hc.Readyz)
+}
+
+// process is alive as long as it serves requests; dependencies aren't checked, so their outage doesn't get the app restarted
+func (hc *HealthController) Healthz(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	return c.Status(http.StatusOK).JSON(dto.HealthResponse{Status: healthStatusOK})
+}
+
+// returns 503 unless every component is healthy
+func (hc *HealthController) Readyz(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	report := hc.healthService.Readiness(c.UserContext())
+
+	resp := dto.HealthResponse{Status: healthStatusOK, Components: make(map[string]dto.ComponentHealthResponse, len(report.Components))}
+	for name, component := range report.Components {
+		status := healthStatusOK
+		if !component.Healthy {
+			status = healthStatusUnavailable
+			hc.log.Warn("readiness check failed", "component", name, "error", component.Error, "cause", component.Cause)
+		}
+		resp.Components[name] = dto.ComponentHealthResponse{Status: status, Error: component.Error, Details: component.Details}
+	}
+	if !report.Ready {
+		resp.Status = healthStatusUnavailable
+		return c.Status(http.StatusServiceUnavailable).JSON(resp)
+	}
+
+	return c.Status(http.StatusOK).JSON(resp)
+}
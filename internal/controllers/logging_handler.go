@@ -5,6 +5,9 @@ import (
 	"time"
 
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
+	"go.opentelemetry.io/otel/trace"
 )
 
 func LoggingHandler(log *slog.Logger) fiber.Handler {
@@ -21,8 +24,15 @@ func LoggingHandler(log *slog.Logger) fiber.Handler {
 			"latency", time.Since(start),
 			"error", err.Error(),
 		)
-		
-		return err
+
+		trace.SpanFromContext(c.UserContext()).RecordError(err)
+		//error is handled right away, so the status code it's mapped to gets into metrics and trace
+		if err := c.App().ErrorHandler(c, err); err != nil {
+			c.Status(fiber.StatusInternalServerError)
+		}
+		observeRequest(c, time.Since(start))
+
+		return nil
 		}
 
 		log.Info("HTTP request results",
@@ -32,7 +42,13 @@ func LoggingHandler(log *slog.Logger) fiber.Handler {
 			"ua", c.Get("User-Agent"),
 			"latency", time.Since(start),
 		)
+		observeRequest(c, time.Since(start))
 
 		return nil
 	}
 }
+
+// route is the pattern of the handler or middleware that answered; method is copied, fiber reuses its memory
+func observeRequest(c *fiber.Ctx, latency time.Duration) {
+	metrics.ObserveHTTPRequest(utils.CopyString(c.Method()), c.Route().Path, c.Response().StatusCode(), latency)
+}
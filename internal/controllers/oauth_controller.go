@@ -0,0 +1,392 @@
+package controllers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/dto"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+// OAuth 2.0 endpoints (RFC 6749) on top of the same sessions /auth routes work with
+type OAuthController struct {
+	authService          *services.AuthService
+	authorizationService *services.AuthorizationService
+	clientService        *services.ClientService
+	publicURL            string
+}
+
+func NewOAuthController(authService *services.AuthService, authorizationService *services.AuthorizationService, clientService *services.ClientService, publicURL string) *OAuthController {
+	return &OAuthController{
+		authService:          authService,
+		authorizationService: authorizationService,
+		clientService:        clientService,
+		publicURL:            publicURL,
+	}
+}
+
+func (oc *OAuthController) RegisterRoutes(router fiber.Router, authMiddleware fiber.Handler) {
+	oauthRouter := router.Group("/oauth")
+	oauthRouter.Get("/authorize", authMiddleware, oc.Authorize)
+	oauthRouter.Post("/token", oc.Token)
+	oauthRouter.Post("/introspect", oc.Introspect)
+	oauthRouter.Post("/revoke", oc.Revoke)
+	//OpenID Connect Core section 5.3.1: both GET and POST must be supported
+	oauthRouter.Get("/userinfo", authMiddleware, oc.UserInfo)
+	oauthRouter.Post("/userinfo", authMiddleware, oc.UserInfo)
+}
+
+// @Summary   OAuth 2.0 authorization endpoint
+// @Description Issues authorization code for the authenticated user and redirects to `redirect_uri` with `code` and `state` (RFC 6749 section 4.1).
+// @Description PKCE with S256 is required. Unknown client or unregistered redirect URI result in 400 without redirect, other errors are sent to `redirect_uri`
+// @Tags      oauth
+// @Security  ApiKeyAuth
+// @Param     response_type          query  string  true   "code"
+// @Param     client_id              query  string  true   "Client ID"
+// @Param     redirect_uri           query  string  false  "One of client's registered redirect URIs; may be omitted if only one is registered"
+// @Param     scope                  query  string  false  "Space-delimited scopes"
+// @Param     state                  query  string  false  "Opaque value returned to the client unchanged"
+// @Param     code_challenge         query  string  true   "BASE64URL(SHA256(code_verifier))"
+// @Param     code_challenge_method  query  string  true   "S256"
+// @Param     nonce                  query  string  false  "OpenID Connect nonce, put into ID token"
+// @Success   302
+// @Failure   400       {object}  dto.OAuthErrorResponse
+// @Failure   401       {object}  dto.FailureResponse
+// @Failure   500       {object}  dto.FailureResponse
+// @Router    /oauth/authorize [get]
+func (oc *OAuthController) Authorize(c *fiber.Ctx) error {
+	const op = "controller:Authorize"
+	//client and redirect URI are checked first: errors can't be redirected to unverified URI (RFC 6749 section 4.1.2.1)
+	client, err := oc.clientService.GetClient(c.UserContext(), c.Query("client_id"))
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "unknown client_id", err)
+		}
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	redirectURI, ok := client.ResolveRedirectURI(c.Query("redirect_uri"))
+	if !ok {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "redirect_uri is not registered for the client", nil)
+	}
+
+	params := url.Values{}
+	if state := c.Query("state"); state != "" {
+		params.Set("state", state)
+	}
+	redirectError := func(code, description string) error {
+		params.Set("error", code)
+		params.Set("error_description", description)
+		return authorizationRedirect(c, redirectURI, params)
+	}
+
+	if c.Query("response_type") != "code" {
+		return redirectError(oauthUnsupportedResponseType, "only code response type is supported")
+	}
+	userID := c.Locals("userid").(uuid.UUID)
+	if !client.AllowsUser(userID) {
+		return redirectError(oauthAccessDenied, "client is not allowed to act for the user")
+	}
+	scope := c.Query("scope")
+	if !scopeAllowed(client, scope) {
+		return redirectError(oauthInvalidScope, "requested scope is not allowed")
+	}
+
+	code, err := oc.authorizationService.IssueCode(c.UserContext(), services.AuthorizationRequest{
+		ClientID:            client.ID,
+		UserID:              userID,
+		AuthTime:            c.Locals("authtime").(time.Time),
+		RedirectURI:         c.Query("redirect_uri"),
+		Scope:               scope,
+		CodeChallenge:       c.Query("code_challenge"),
+		CodeChallengeMethod: c.Query("code_challenge_method"),
+		Nonce:               c.Query("nonce"),
+	})
+	if err != nil {
+		if errors.Is(err, entities.ErrBadRequest) {
+			return redirectError(oauthInvalidRequest, "code_challenge with S256 code_challenge_method is required")
+		}
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	params.Set("code", code)
+
+	return authorizationRedirect(c, redirectURI, params)
+}
+
+// @Summary   OpenID Connect UserInfo endpoint
+// @Description Returns claims about the user the access token was issued for; the session must be granted `openid` scope
+// @Tags      oauth
+// @Security  ApiKeyAuth
+// @Produce   json
+// @Success   200       {object}  dto.UserInfoResponse
+// @Failure   401       {object}  dto.FailureResponse
+// @Failure   403       {object}  dto.FailureResponse
+// @Failure   500       {object}  dto.FailureResponse
+// @Router    /oauth/userinfo [get]
+func (oc *OAuthController) UserInfo(c *fiber.Ctx) error {
+	const op = "controller:UserInfo"
+	if !slices.Contains(strings.Fields(c.Locals("scope").(string)), entities.ScopeOpenID) {
+		c.Set(fiber.HeaderWWWAuthenticate, `Bearer error="insufficient_scope", scope="openid"`)
+		return fmt.Errorf("%s:%w", op, ErrForbidden)
+	}
+	userID := c.Locals("userid").(uuid.UUID)
+
+	return c.Status(200).JSON(&dto.UserInfoResponse{Subject: userID.String()})
+}
+
+// redirects to redirectURI with params added to its query
+func authorizationRedirect(c *fiber.Ctx, redirectURI string, params url.Values) error {
+	u, err := url.Parse(redirectURI)
+	if err != nil {
+		return err
+	}
+	query := u.Query()
+	for key, values := range params {
+		query[key] = values
+	}
+	u.RawQuery = query.Encode()
+
+	return c.Redirect(u.String(), http.StatusFound)
+}
+
+// @Summary   OAuth 2.0 token endpoint
+// @Description Supports `authorization_code` (with PKCE), `client_credentials` (tokens are issued for `user_id`, subject to client's user policy) and `refresh_token` grants.
+// @Description Client authenticates with HTTP Basic auth, `client_id`+`client_secret` or a JWT client assertion; public clients send only `client_id` with `authorization_code` and `refresh_token` grants. Errors follow RFC 6749 section 5.2
+// @Tags      oauth
+// @Security  BasicAuth
+// @Accept    x-www-form-urlencoded
+// @Produce   json
+// @Param     grant_type             formData  string  true   "authorization_code, client_credentials or refresh_token"
+// @Param     code                   formData  string  false  "Authorization code, required for authorization_code"
+// @Param     redirect_uri           formData  string  false  "Must match redirect_uri of authorization request, if it was sent"
+// @Param     code_verifier          formData  string  false  "PKCE code verifier, required for authorization_code"
+// @Param     user_id                formData  string  false  "User GUID, required for client_credentials"
+// @Param     refresh_token          formData  string  false  "Refresh token, required for refresh_token"
+// @Param     scope                  formData  string  false  "Space-delimited scopes"
+// @Param     client_id              formData  string  false  "Client ID, if client_secret is sent in the body or client is public"
+// @Param     client_secret          formData  string  false  "Client secret"
+// @Param     client_assertion_type  formData  string  false  "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param     client_assertion       formData  string  false  "JWT signed with client's private key"
+// @Success   200       {object}  dto.OAuthTokenResponse
+// @Failure   400       {object}  dto.OAuthErrorResponse
+// @Failure   401       {object}  dto.OAuthErrorResponse
+// @Failure   500       {object}  dto.FailureResponse
+// @Router    /oauth/token [post]
+func (oc *OAuthController) Token(c *fiber.Ctx) error {
+	const op = "controller:Token"
+	//token responses must never be cached (RFC 6749 section 5.1)
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	c.Set(fiber.HeaderPragma, "no-cache")
+
+	if !c.Is("application/x-www-form-urlencoded") {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "request must be form-encoded", nil)
+	}
+
+	grantType := c.FormValue("grant_type")
+	client, err := oc.authenticate(c, grantType == "authorization_code" || grantType == "refresh_token")
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	userAgent := c.Get("User-Agent")
+	if userAgent == "" {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "User-Agent header is required", nil)
+	}
+
+	var tokens services.Tokens
+	switch grantType {
+	case "authorization_code":
+		tokens, err = oc.authorizationCode(c, client, userAgent)
+	case "client_credentials":
+		tokens, err = oc.clientCredentials(c, client, userAgent)
+	case "refresh_token":
+		tokens, err = oc.refreshToken(c, client, userAgent)
+	case "":
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "grant_type is required", nil)
+	default:
+		return newOAuthError(http.StatusBadRequest, oauthUnsupportedGrantType, "", nil)
+	}
+	if err != nil {
+		return toOAuthError(err)
+	}
+
+	return c.Status(200).JSON(oauthTokenResponse(tokens))
+}
+
+// @Summary   OAuth 2.0 token introspection
+// @Description Reports whether access or refresh token is live: signature and expiry of access token are checked along with its session, which must be neither revoked nor expired (RFC 7662).
+// @Description Client authenticates the same way as at the token endpoint; public clients can't introspect tokens
+// @Tags      oauth
+// @Security  BasicAuth
+// @Accept    x-www-form-urlencoded
+// @Produce   json
+// @Param     token                  formData  string  true   "Access or refresh token"
+// @Param     token_type_hint        formData  string  false  "access_token or refresh_token"
+// @Param     client_id              formData  string  false  "Client ID, if client_secret is sent in the body"
+// @Param     client_secret          formData  string  false  "Client secret"
+// @Param     client_assertion_type  formData  string  false  "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param     client_assertion       formData  string  false  "JWT signed with client's private key"
+// @Success   200       {object}  dto.IntrospectionResponse
+// @Failure   400       {object}  dto.OAuthErrorResponse
+// @Failure   401       {object}  dto.OAuthErrorResponse
+// @Failure   500       {object}  dto.FailureResponse
+// @Router    /oauth/introspect [post]
+func (oc *OAuthController) Introspect(c *fiber.Ctx) error {
+	const op = "controller:Introspect"
+	c.Set(fiber.HeaderCacheControl, "no-store")
+
+	if !c.Is("application/x-www-form-urlencoded") {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "request must be form-encoded", nil)
+	}
+	if _, err := oc.authenticate(c, false); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	tokenString := c.FormValue("token")
+	if tokenString == "" {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "token is required", nil)
+	}
+
+	info, err := oc.authService.Introspect(c.UserContext(), tokenString, c.FormValue("token_type_hint"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if !info.Active {
+		return c.Status(200).JSON(&dto.IntrospectionResponse{Active: false})
+	}
+
+	resp := &dto.IntrospectionResponse{
+		Active:    true,
+		Subject:   info.UserID.String(),
+		ExpiresAt: info.ExpiresAt.Unix(),
+		JTI:       info.SessionID.String(),
+		ClientID:  info.ClientID,
+		Scope:     info.Scope,
+	}
+	if !info.IssuedAt.IsZero() {
+		resp.IssuedAt = info.IssuedAt.Unix()
+	}
+	if info.TokenType == services.TokenTypeAccess {
+		resp.TokenType = "Bearer"
+	}
+
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary   OAuth 2.0 token revocation
+// @Description Revokes the session access or refresh token belongs to (RFC 7009); expired access tokens are accepted.
+// @Description Responds with 200 whether the token was revoked, unknown or issued to another client. Public clients send only `client_id`
+// @Tags      oauth
+// @Security  BasicAuth
+// @Accept    x-www-form-urlencoded
+// @Param     token                  formData  string  true   "Access or refresh token"
+// @Param     token_type_hint        formData  string  false  "access_token or refresh_token"
+// @Param     client_id              formData  string  false  "Client ID, if client_secret is sent in the body or client is public"
+// @Param     client_secret          formData  string  false  "Client secret"
+// @Param     client_assertion_type  formData  string  false  "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+// @Param     client_assertion       formData  string  false  "JWT signed with client's private key"
+// @Success   200
+// @Failure   400       {object}  dto.OAuthErrorResponse
+// @Failure   401       {object}  dto.OAuthErrorResponse
+// @Failure   500       {object}  dto.FailureResponse
+// @Router    /oauth/revoke [post]
+func (oc *OAuthController) Revoke(c *fiber.Ctx) error {
+	const op = "controller:Revoke"
+	if !c.Is("application/x-www-form-urlencoded") {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "request must be form-encoded", nil)
+	}
+	client, err := oc.authenticate(c, true)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	tokenString := c.FormValue("token")
+	if tokenString == "" {
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "token is required", nil)
+	}
+
+	if err := oc.authService.RevokeToken(c.UserContext(), client.ID, tokenString, c.FormValue("token_type_hint")); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return c.SendStatus(200)
+}
+
+// authenticates client calling token, introspection or revocation endpoint;
+// public clients, identified by client_id alone, are accepted only if allowPublic is set
+func (oc *OAuthController) authenticate(c *fiber.Ctx, allowPublic bool) (*entities.Client, error) {
+	client, err := authenticateClient(c, oc.clientService, oc.publicURL)
+	if errors.Is(err, errNoClientCredentials) && allowPublic && c.FormValue("client_id") != "" {
+		client, err = oc.clientService.IdentifyPublicClient(c.UserContext(), c.FormValue("client_id"))
+	}
+	if err != nil {
+		if errors.Is(err, ErrUnauthorized) {
+			return nil, newOAuthError(http.StatusUnauthorized, oauthInvalidClient, "client authentication failed", err)
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
+
+func (oc *OAuthController) authorizationCode(c *fiber.Ctx, client *entities.Client, userAgent string) (services.Tokens, error) {
+	code, codeVerifier := c.FormValue("code"), c.FormValue("code_verifier")
+	if code == "" || codeVerifier == "" {
+		return services.Tokens{}, newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "code and code_verifier are required", nil)
+	}
+
+	return oc.authorizationService.ExchangeCode(c.UserContext(), client.ID, code, c.FormValue("redirect_uri"), codeVerifier, c.IP(), userAgent)
+}
+
+func (oc *OAuthController) clientCredentials(c *fiber.Ctx, client *entities.Client, userAgent string) (services.Tokens, error) {
+	const op = "controller:clientCredentials"
+	userID, err := uuid.Parse(c.FormValue("user_id"))
+	if err != nil {
+		return services.Tokens{}, newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "user_id must be a valid GUID", nil)
+	}
+	if !client.AllowsUser(userID) {
+		return services.Tokens{}, fmt.Errorf("%s:%w", op, ErrForbidden)
+	}
+	scope := c.FormValue("scope")
+	if !scopeAllowed(client, scope) {
+		return services.Tokens{}, fmt.Errorf("%s:%w", op, entities.ErrInvalidScope)
+	}
+
+	return oc.authService.GenerateTokens(c.UserContext(), client.ID, userID, scope, c.IP(), userAgent)
+}
+
+func (oc *OAuthController) refreshToken(c *fiber.Ctx, client *entities.Client, userAgent string) (services.Tokens, error) {
+	refreshToken := c.FormValue("refresh_token")
+	if refreshToken == "" {
+		return services.Tokens{}, newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "refresh_token is required", nil)
+	}
+
+	return oc.authService.RefreshByToken(c.UserContext(), client.ID, refreshToken, c.FormValue("scope"), c.IP(), userAgent)
+}
+
+func oauthTokenResponse(tokens services.Tokens) *dto.OAuthTokenResponse {
+	return &dto.OAuthTokenResponse{
+		AccessToken:  tokens.AccessToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(tokens.ExpiresIn.Seconds()),
+		RefreshToken: tokens.RefreshToken,
+		Scope:        tokens.Scope,
+		IDToken:      tokens.IDToken,
+	}
+}
+
+// checks scope against the client's allowed scopes; openid is refused while ID tokens can't be signed,
+// as they'd be signed with the HMAC secret otherwise
+func scopeAllowed(client *entities.Client, scope string) bool {
+	if slices.Contains(strings.Fields(scope), entities.ScopeOpenID) && !token.IDTokensSupported() {
+		return false
+	}
+
+	return client.AllowsScope(scope)
+}
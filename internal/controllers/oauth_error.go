@@ -0,0 +1,64 @@
+package controllers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+// OAuth 2.0 error codes (RFC 6749 section 5.2)
+const (
+	oauthInvalidRequest       = "invalid_request"
+	oauthInvalidClient        = "invalid_client"
+	oauthInvalidGrant         = "invalid_grant"
+	oauthUnauthorizedClient   = "unauthorized_client"
+	oauthUnsupportedGrantType = "unsupported_grant_type"
+	oauthInvalidScope         = "invalid_scope"
+	//authorization endpoint only (RFC 6749 section 4.1.2.1)
+	oauthAccessDenied            = "access_denied"
+	oauthUnsupportedResponseType = "unsupported_response_type"
+)
+
+// error rendered by ErrHandler in RFC 6749 format instead of the usual one
+type OAuthError struct {
+	Status      int
+	Code        string
+	Description string
+	//cause, for logs only
+	Err error
+}
+
+func (e *OAuthError) Error() string {
+	if e.Err != nil {
+		return e.Code + ": " + e.Err.Error()
+	}
+	return e.Code + ": " + e.Description
+}
+
+func (e *OAuthError) Unwrap() error {
+	return e.Err
+}
+
+func newOAuthError(status int, code, description string, err error) *OAuthError {
+	return &OAuthError{Status: status, Code: code, Description: description, Err: err}
+}
+
+// maps service error to OAuth error; unknown errors are left as is and end up as 500
+func toOAuthError(err error) error {
+	switch {
+	case errors.Is(err, entities.ErrInvalidScope):
+		return newOAuthError(http.StatusBadRequest, oauthInvalidScope, "requested scope is not allowed", err)
+	case errors.Is(err, entities.ErrForbidden):
+		return newOAuthError(http.StatusBadRequest, oauthUnauthorizedClient, "client is not allowed to make this request", err)
+	case errors.Is(err, entities.ErrSessionLimit):
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "maximum number of sessions is reached", err)
+	case errors.Is(err, entities.ErrBadRequest):
+		return newOAuthError(http.StatusBadRequest, oauthInvalidRequest, "", err)
+	case errors.Is(err, entities.ErrUnauthorized), errors.Is(err, entities.ErrRevoked), errors.Is(err, entities.ErrReused),
+		errors.Is(err, entities.ErrExpired), errors.Is(err, entities.ErrNotFound):
+		return newOAuthError(http.StatusBadRequest, oauthInvalidGrant, "grant is invalid, expired or revoked", err)
+	default:
+		return err
+	}
+}
@@ -0,0 +1,48 @@
+package controllers
+
+import (
+	"net/http"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// starts server span of the request, continuing the trace from traceparent header if there is one;
+// handlers get the span with c.UserContext(). Must be registered before LoggingHandler, which sets status code of failed requests
+func TracingHandler() fiber.Handler {
+	tracer := otel.Tracer("github.com/superdumb33/auth-service-test/internal/controllers")
+
+	return func(c *fiber.Ctx) error {
+		method := utils.CopyString(c.Method())
+		ctx := otel.GetTextMapPropagator().Extract(c.UserContext(), propagation.HeaderCarrier(c.GetReqHeaders()))
+		ctx, span := tracer.Start(ctx, method, trace.WithSpanKind(trace.SpanKindServer), trace.WithAttributes(
+			attribute.String("http.request.method", method),
+			attribute.String("url.path", utils.CopyString(c.Path())),
+			attribute.String("client.address", utils.CopyString(c.IP())),
+			attribute.String("user_agent.original", utils.CopyString(c.Get(fiber.HeaderUserAgent))),
+		))
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		route := c.Route().Path
+		status := c.Response().StatusCode()
+		span.SetName(method + " " + route)
+		span.SetAttributes(attribute.String("http.route", route), attribute.Int("http.response.status_code", status))
+		if err != nil {
+			span.RecordError(err)
+		}
+		//client errors are fine as far as the server is concerned
+		if err != nil || status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+
+		return err
+	}
+}
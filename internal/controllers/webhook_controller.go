@@ -0,0 +1,214 @@
+package controllers
+
+import (
+	"fmt"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/dto"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+// admin API of webhook subscriptions
+type WebhookController struct {
+	service *services.WebhookSubscriptionService
+}
+
+func NewWebhookController(service *services.WebhookSubscriptionService) *WebhookController {
+	return &WebhookController{service: service}
+}
+
+func (wc *WebhookController) RegisterRoutes(router fiber.Router, adminMiddleware fiber.Handler) {
+	webhooksRouter := router.Group("/admin/webhooks", adminMiddleware)
+	webhooksRouter.Post("/", wc.Create)
+	webhooksRouter.Get("/", wc.List)
+	webhooksRouter.Get("/:id", wc.Get)
+	webhooksRouter.Patch("/:id", wc.Update)
+	webhooksRouter.Delete("/:id", wc.Delete)
+}
+
+// @Summary   Create webhook subscription
+// @Description Secret is generated if absent; it's returned only by this request and by an update changing it
+// @Tags      admin
+// @Security  AdminAuth
+// @Accept    json
+// @Produce   json
+// @Param     subscription  body  dto.WebhookSubscriptionRequest  true  "url is required"
+// @Success   201  {object}  dto.WebhookSubscriptionResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Router    /admin/webhooks [post]
+func (wc *WebhookController) Create(c *fiber.Ctx) error {
+	const op = "controller:CreateWebhookSubscription"
+	params, err := webhookSubscriptionParams(c)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	sub, err := wc.service.Create(c.UserContext(), params)
+	if err != nil {
+		return err
+	}
+
+	resp := webhookSubscriptionResponse(sub)
+	resp.Secret = sub.Secret
+	return c.Status(201).JSON(resp)
+}
+
+// @Summary   List webhook subscriptions
+// @Tags      admin
+// @Security  AdminAuth
+// @Produce   json
+// @Success   200  {object}  dto.ListWebhookSubscriptionsResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Router    /admin/webhooks [get]
+func (wc *WebhookController) List(c *fiber.Ctx) error {
+	subs, err := wc.service.List(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	resp := &dto.ListWebhookSubscriptionsResponse{Subscriptions: make([]dto.WebhookSubscriptionResponse, 0, len(subs))}
+	for _, sub := range subs {
+		resp.Subscriptions = append(resp.Subscriptions, webhookSubscriptionResponse(sub))
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary   Get webhook subscription
+// @Tags      admin
+// @Security  AdminAuth
+// @Produce   json
+// @Param     id   path  string  true  "Subscription ID"
+// @Success   200  {object}  dto.WebhookSubscriptionResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   404  {object}  dto.FailureResponse
+// @Router    /admin/webhooks/{id} [get]
+func (wc *WebhookController) Get(c *fiber.Ctx) error {
+	const op = "controller:GetWebhookSubscription"
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+
+	sub, err := wc.service.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(200).JSON(webhookSubscriptionResponse(sub))
+}
+
+// @Summary   Update webhook subscription
+// @Description Only the fields present are changed
+// @Tags      admin
+// @Security  AdminAuth
+// @Accept    json
+// @Produce   json
+// @Param     id            path  string                          true  "Subscription ID"
+// @Param     subscription  body  dto.WebhookSubscriptionRequest  true  "fields to change"
+// @Success   200  {object}  dto.WebhookSubscriptionResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   404  {object}  dto.FailureResponse
+// @Router    /admin/webhooks/{id} [patch]
+func (wc *WebhookController) Update(c *fiber.Ctx) error {
+	const op = "controller:UpdateWebhookSubscription"
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+	params, err := webhookSubscriptionParams(c)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	sub, err := wc.service.Update(c.UserContext(), id, params)
+	if err != nil {
+		return err
+	}
+
+	resp := webhookSubscriptionResponse(sub)
+	if params.Secret != nil {
+		resp.Secret = sub.Secret
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary   Delete webhook subscription
+// @Description Webhooks queued for the subscription are dropped
+// @Tags      admin
+// @Security  AdminAuth
+// @Param     id   path  string  true  "Subscription ID"
+// @Success   204
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   404  {object}  dto.FailureResponse
+// @Router    /admin/webhooks/{id} [delete]
+func (wc *WebhookController) Delete(c *fiber.Ctx) error {
+	const op = "controller:DeleteWebhookSubscription"
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+
+	if err := wc.service.Delete(c.UserContext(), id); err != nil {
+		return err
+	}
+
+	return c.SendStatus(204)
+}
+
+func webhookSubscriptionParams(c *fiber.Ctx) (services.WebhookSubscriptionParams, error) {
+	var request dto.WebhookSubscriptionRequest
+	if err := c.BodyParser(&request); err != nil {
+		return services.WebhookSubscriptionParams{}, ErrBadRequest
+	}
+	params := services.WebhookSubscriptionParams{
+		Name:    request.Name,
+		URL:     request.URL,
+		Secret:  request.Secret,
+		Enabled: request.Enabled,
+	}
+	if request.EventTypes != nil {
+		eventTypes := make([]entities.EventType, 0, len(*request.EventTypes))
+		for _, eventType := range *request.EventTypes {
+			eventTypes = append(eventTypes, entities.EventType(eventType))
+		}
+		params.EventTypes = &eventTypes
+	}
+	if request.Format != nil {
+		format := entities.WebhookFormat(*request.Format)
+		params.Format = &format
+	}
+
+	return params, nil
+}
+
+func webhookSubscriptionResponse(sub *entities.WebhookSubscription) dto.WebhookSubscriptionResponse {
+	resp := dto.WebhookSubscriptionResponse{
+		ID:         sub.ID.String(),
+		Name:       sub.Name,
+		URL:        sub.URL,
+		EventTypes: make([]string, 0, len(sub.EventTypes)),
+		Format:     string(sub.Format),
+		Enabled:    sub.Enabled,
+		CreatedAt:  sub.CreatedAt,
+		UpdatedAt:  sub.UpdatedAt,
+		Stats: dto.WebhookStatsResponse{
+			Delivered:       sub.Stats.Delivered,
+			FailedAttempts:  sub.Stats.FailedAttempts,
+			Dead:            sub.Stats.Dead,
+			LastDeliveredAt: sub.Stats.LastDeliveredAt,
+			LastFailedAt:    sub.Stats.LastFailedAt,
+			LastError:       sub.Stats.LastError,
+		},
+	}
+	for _, eventType := range sub.EventTypes {
+		resp.EventTypes = append(resp.EventTypes, string(eventType))
+	}
+
+	return resp
+}
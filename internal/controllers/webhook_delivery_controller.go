@@ -0,0 +1,164 @@
+package controllers
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/dto"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+// admin API of webhook delivery attempts
+type WebhookDeliveryController struct {
+	service *services.WebhookDeliveryService
+}
+
+func NewWebhookDeliveryController(service *services.WebhookDeliveryService) *WebhookDeliveryController {
+	return &WebhookDeliveryController{service: service}
+}
+
+func (dc *WebhookDeliveryController) RegisterRoutes(router fiber.Router, adminMiddleware fiber.Handler) {
+	deliveriesRouter := router.Group("/admin/webhook-deliveries", adminMiddleware)
+	deliveriesRouter.Get("/", dc.List)
+	deliveriesRouter.Get("/:id", dc.Get)
+	deliveriesRouter.Post("/:id/replay", dc.Replay)
+}
+
+// @Summary   List webhook delivery attempts
+// @Description Newest first; only the ones matching every given filter are listed
+// @Tags      admin
+// @Security  AdminAuth
+// @Produce   json
+// @Param     user_id          query  string  false  "User the event is about"
+// @Param     event_id         query  string  false  "Event ID"
+// @Param     event            query  string  false  "Event type"
+// @Param     subscription_id  query  string  false  "Subscription ID"
+// @Param     status           query  string  false  "succeeded or failed"
+// @Param     limit            query  int     false  "Page size, 1 to 100"  default(20)
+// @Param     offset           query  int     false  "Number of deliveries to skip"  default(0)
+// @Success   200  {object}  dto.ListWebhookDeliveriesResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Router    /admin/webhook-deliveries [get]
+func (dc *WebhookDeliveryController) List(c *fiber.Ctx) error {
+	const op = "controller:ListWebhookDeliveries"
+	limit, err := strconv.Atoi(c.Query("limit", "20"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+	offset, err := strconv.Atoi(c.Query("offset", "0"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+	params := entities.WebhookDeliveryListParams{
+		Limit:  limit,
+		Offset: offset,
+		Event:  entities.EventType(c.Query("event")),
+		Status: entities.DeliveryStatus(c.Query("status")),
+	}
+	for key, id := range map[string]**uuid.UUID{
+		"user_id":         &params.UserID,
+		"event_id":        &params.EventID,
+		"subscription_id": &params.SubscriptionID,
+	} {
+		if raw := c.Query(key); raw != "" {
+			parsed, err := uuid.Parse(raw)
+			if err != nil {
+				return fmt.Errorf("%s:%w", op, ErrBadRequest)
+			}
+			*id = &parsed
+		}
+	}
+
+	deliveries, total, err := dc.service.List(c.UserContext(), params)
+	if err != nil {
+		return err
+	}
+
+	resp := &dto.ListWebhookDeliveriesResponse{
+		Deliveries: make([]dto.WebhookDeliveryResponse, 0, len(deliveries)),
+		Total:      total,
+		Limit:      limit,
+		Offset:     offset,
+	}
+	for _, delivery := range deliveries {
+		resp.Deliveries = append(resp.Deliveries, webhookDeliveryResponse(delivery))
+	}
+	return c.Status(200).JSON(resp)
+}
+
+// @Summary   Get webhook delivery attempt
+// @Tags      admin
+// @Security  AdminAuth
+// @Produce   json
+// @Param     id   path  string  true  "Delivery ID"
+// @Success   200  {object}  dto.WebhookDeliveryResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   404  {object}  dto.FailureResponse
+// @Router    /admin/webhook-deliveries/{id} [get]
+func (dc *WebhookDeliveryController) Get(c *fiber.Ctx) error {
+	const op = "controller:GetWebhookDelivery"
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+
+	delivery, err := dc.service.Get(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(200).JSON(webhookDeliveryResponse(delivery))
+}
+
+// @Summary   Replay failed webhook delivery
+// @Description Queues the message of the delivery again if it's dead or waits for a retry; 409 if it's delivered or being delivered, 404 if it's purged
+// @Tags      admin
+// @Security  AdminAuth
+// @Produce   json
+// @Param     id   path  string  true  "Delivery ID"
+// @Success   202  {object}  dto.ReplayWebhookDeliveryResponse
+// @Failure   400  {object}  dto.FailureResponse
+// @Failure   401  {object}  dto.FailureResponse
+// @Failure   404  {object}  dto.FailureResponse
+// @Failure   409  {object}  dto.FailureResponse
+// @Router    /admin/webhook-deliveries/{id}/replay [post]
+func (dc *WebhookDeliveryController) Replay(c *fiber.Ctx) error {
+	const op = "controller:ReplayWebhookDelivery"
+	id, err := uuid.Parse(c.Params("id"))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, ErrBadRequest)
+	}
+
+	delivery, err := dc.service.Replay(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.Status(202).JSON(&dto.ReplayWebhookDeliveryResponse{OutboxID: delivery.OutboxID.String()})
+}
+
+func webhookDeliveryResponse(delivery *entities.WebhookDelivery) dto.WebhookDeliveryResponse {
+	return dto.WebhookDeliveryResponse{
+		ID:             delivery.ID.String(),
+		OutboxID:       delivery.OutboxID.String(),
+		SubscriptionID: delivery.SubscriptionID.String(),
+		EventID:        delivery.EventID.String(),
+		Event:          string(delivery.Event),
+		UserID:         delivery.UserID.String(),
+		Attempt:        delivery.Attempt,
+		Status:         string(delivery.Status),
+		RequestURL:     delivery.RequestURL,
+		RequestHeaders: delivery.RequestHeaders,
+		RequestBody:    delivery.RequestBody,
+		ResponseStatus: delivery.ResponseStatus,
+		ResponseBody:   delivery.ResponseBody,
+		LatencyMs:      delivery.Latency.Milliseconds(),
+		Error:          delivery.Error,
+		CreatedAt:      delivery.CreatedAt,
+	}
+}
@@ -0,0 +1,66 @@
+package controllers
+
+import (
+	"github.com/gofiber/fiber/v2"
+	"github.com/superdumb33/auth-service-test/internal/dto"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+// serves public discovery documents; routes are registered on the server root, outside of the versioned API
+type WellKnownController struct {
+	issuer string
+	//path of the versioned API OAuth endpoints are served under, e.g. /api/v1
+	apiPath string
+	//see ClientAuthMethods
+	clientAuthMethods []string
+}
+
+func NewWellKnownController(issuer, apiPath string, clientAuthMethods []string) *WellKnownController {
+	return &WellKnownController{issuer: issuer, apiPath: apiPath, clientAuthMethods: clientAuthMethods}
+}
+
+func (wc *WellKnownController) RegisterRoutes(router fiber.Router) {
+	wellKnownRouter := router.Group("/.well-known")
+	wellKnownRouter.Get("/jwks.json", wc.JWKS)
+	wellKnownRouter.Get("/openid-configuration", wc.OpenIDConfiguration)
+}
+
+// returns OpenID Connect provider metadata; issuer must be the same as iss of issued tokens, so it's never derived from request
+func (wc *WellKnownController) OpenIDConfiguration(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+	apiURL := wc.issuer + wc.apiPath
+	//openid is refused until there is an asymmetric key to sign ID tokens with
+	scopes := []string{}
+	if token.IDTokensSupported() {
+		scopes = append(scopes, entities.ScopeOpenID)
+	}
+
+	return c.Status(200).JSON(&dto.OpenIDConfiguration{
+		Issuer:                            wc.issuer,
+		AuthorizationEndpoint:             apiURL + "/oauth/authorize",
+		TokenEndpoint:                     apiURL + "/oauth/token",
+		UserInfoEndpoint:                  apiURL + "/oauth/userinfo",
+		JWKSURI:                           wc.issuer + "/.well-known/jwks.json",
+		ResponseTypesSupported:            []string{"code"},
+		GrantTypesSupported:               []string{"authorization_code", "client_credentials", "refresh_token"},
+		SubjectTypesSupported:             []string{"public"},
+		IDTokenSigningAlgValuesSupported:  token.SigningAlgorithms(),
+		ScopesSupported:                   scopes,
+		TokenEndpointAuthMethodsSupported: wc.clientAuthMethods,
+		ClaimsSupported:                   []string{"iss", "sub", "aud", "exp", "iat", "auth_time", "nonce", "sid"},
+		CodeChallengeMethodsSupported:     []string{token.PKCEMethodS256},
+		//clients authenticate at introspection and revocation the same way as at the token endpoint
+		IntrospectionEndpoint:                     apiURL + "/oauth/introspect",
+		IntrospectionEndpointAuthMethodsSupported: wc.clientAuthMethods,
+		RevocationEndpoint:                        apiURL + "/oauth/revoke",
+		RevocationEndpointAuthMethodsSupported:    wc.clientAuthMethods,
+	})
+}
+
+//returns public keys to verify access tokens with; set is empty when tokens are signed with a shared HMAC secret
+func (wc *WellKnownController) JWKS(c *fiber.Ctx) error {
+	c.Set(fiber.HeaderCacheControl, "public, max-age=300")
+
+	return c.Status(200).JSON(token.PublicJWKS())
+}
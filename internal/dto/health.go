@@ -0,0 +1,13 @@
+package dto
+
+type HealthResponse struct {
+	//ok or unavailable
+	Status     string                             `json:"status"`
+	Components map[string]ComponentHealthResponse `json:"components,omitempty"`
+}
+
+type ComponentHealthResponse struct {
+	Status  string            `json:"status"`
+	Error   string            `json:"error,omitempty"`
+	Details map[string]string `json:"details,omitempty"`
+}
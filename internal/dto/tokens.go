@@ -1,5 +1,7 @@
 package dto
 
+import "time"
+
 type IssueTokensResponse struct {
 	AccessToken  string `json:"access_token"`
 	RefreshToken string `json:"refresh_token"`
@@ -22,4 +24,29 @@ type GetCurrentUserIDResponse struct{
 
 type FailureResponse struct{
 	Error string `json:"error"`
+}
+
+type SessionResponse struct {
+	ID        string    `json:"id"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UserAgent string    `json:"user_agent"`
+	IPAddress string    `json:"ip_address"`
+	//time the user logged in at
+	AuthTime   time.Time `json:"auth_time"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	//session can't be refreshed after that, however often it's refreshed; omitted if lifetime is unlimited
+	AbsoluteExpiresAt *time.Time `json:"absolute_expires_at,omitempty"`
+	//session expires at that time unless it's used; omitted if there's no idle timeout
+	IdleExpiresAt *time.Time `json:"idle_expires_at,omitempty"`
+	//session of the access token used for the request
+	IsCurrent bool `json:"is_current"`
+}
+
+type ListSessionsResponse struct {
+	Sessions []SessionResponse `json:"sessions"`
+	//number of active sessions across all pages
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
 }
\ No newline at end of file
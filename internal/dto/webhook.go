@@ -0,0 +1,78 @@
+package dto
+
+import "time"
+
+// absent fields are left as they are on update
+type WebhookSubscriptionRequest struct {
+	Name *string `json:"name"`
+	URL  *string `json:"url"`
+	//generated on create if absent
+	Secret *string `json:"secret"`
+	//empty for every event
+	EventTypes *[]string `json:"event_types"`
+	//legacy, cloudevents_structured or cloudevents_binary; legacy by default
+	Format  *string `json:"format"`
+	Enabled *bool   `json:"enabled"`
+}
+
+type WebhookSubscriptionResponse struct {
+	ID         string               `json:"id"`
+	Name       string               `json:"name"`
+	URL        string               `json:"url"`
+	EventTypes []string             `json:"event_types"`
+	Format     string               `json:"format"`
+	Enabled    bool                 `json:"enabled"`
+	CreatedAt  time.Time            `json:"created_at"`
+	UpdatedAt  time.Time            `json:"updated_at"`
+	Stats      WebhookStatsResponse `json:"stats"`
+	//only in response to create, or to update changing it
+	Secret string `json:"secret,omitempty"`
+}
+
+type WebhookStatsResponse struct {
+	Delivered       int64      `json:"delivered"`
+	FailedAttempts  int64      `json:"failed_attempts"`
+	Dead            int64      `json:"dead"`
+	LastDeliveredAt *time.Time `json:"last_delivered_at,omitempty"`
+	LastFailedAt    *time.Time `json:"last_failed_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+}
+
+type ListWebhookSubscriptionsResponse struct {
+	Subscriptions []WebhookSubscriptionResponse `json:"subscriptions"`
+}
+
+type WebhookDeliveryResponse struct {
+	ID string `json:"id"`
+	//outbox message the attempt delivered
+	OutboxID       string            `json:"outbox_id"`
+	SubscriptionID string            `json:"subscription_id"`
+	EventID        string            `json:"event_id"`
+	Event          string            `json:"event"`
+	UserID         string            `json:"user_id"`
+	Attempt        int               `json:"attempt"`
+	Status         string            `json:"status"`
+	RequestURL     string            `json:"request_url"`
+	RequestHeaders map[string]string `json:"request_headers"`
+	RequestBody    string            `json:"request_body"`
+	//absent if no response was received
+	ResponseStatus int `json:"response_status,omitempty"`
+	//truncated to 4 KiB
+	ResponseBody string    `json:"response_body"`
+	LatencyMs    int64     `json:"latency_ms"`
+	Error        string    `json:"error,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+type ListWebhookDeliveriesResponse struct {
+	Deliveries []WebhookDeliveryResponse `json:"deliveries"`
+	//number of matching deliveries across all pages
+	Total  int `json:"total"`
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+}
+
+type ReplayWebhookDeliveryResponse struct {
+	//outbox message queued again
+	OutboxID string `json:"outbox_id"`
+}
@@ -0,0 +1,29 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// single-use OAuth authorization code bound to PKCE challenge
+type AuthorizationCode struct {
+	//sha256 of the code
+	CodeHash            string
+	ClientID            string
+	UserID              uuid.UUID
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	//OpenID Connect nonce, put into ID token as is
+	Nonce string
+	//time the user authenticated at
+	AuthTime  time.Time
+	IssuedAt  time.Time
+	ExpiresAt time.Time
+	//nil until the code is exchanged
+	UsedAt *time.Time
+	//session the code was exchanged for
+	SessionID *uuid.UUID
+}
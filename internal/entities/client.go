@@ -0,0 +1,72 @@
+package entities
+
+import (
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// scope requesting OpenID Connect ID token; clients need it in AllowedScopes like any other scope
+const ScopeOpenID = "openid"
+
+// trusted client allowed to issue tokens
+type Client struct {
+	ID   string
+	Name string
+	//bcrypt hash of client secret; empty if client authenticates with assertions only
+	SecretHash string
+	//PEM encoded public key verifying signed client assertions; empty if client authenticates with secret only
+	PublicKey string
+	//if false, client may issue tokens only for users listed in AllowedUserIDs
+	AllowAnyUser   bool
+	AllowedUserIDs []uuid.UUID
+	//OAuth scopes client may request
+	AllowedScopes []string
+	//exact URIs authorization codes may be sent to
+	RedirectURIs []string
+	CreatedAt    time.Time
+}
+
+// public clients (SPA, native apps) can't keep a secret, they only use authorization code flow with PKCE
+func (c *Client) IsPublic() bool {
+	return c.SecretHash == "" && c.PublicKey == ""
+}
+
+// returns registered redirect URI matching requested one exactly; if nothing is requested,
+// the only registered URI is used
+func (c *Client) ResolveRedirectURI(requested string) (string, bool) {
+	if requested == "" {
+		if len(c.RedirectURIs) == 1 {
+			return c.RedirectURIs[0], true
+		}
+		return "", false
+	}
+
+	return requested, slices.Contains(c.RedirectURIs, requested)
+}
+
+func (c *Client) AllowsUser(userID uuid.UUID) bool {
+	if c.AllowAnyUser {
+		return true
+	}
+	for _, id := range c.AllowedUserIDs {
+		if id == userID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// checks that every space-delimited scope is allowed
+func (c *Client) AllowsScope(scope string) bool {
+	for _, requested := range strings.Fields(scope) {
+		if !slices.Contains(c.AllowedScopes, requested) {
+			return false
+		}
+	}
+
+	return true
+}
@@ -9,5 +9,10 @@ var (
 	ErrExpired = errors.New("expired")
 	ErrBadRequest = errors.New("bad request")
 	ErrUnauthorized = errors.New("unauthorized")
+	ErrForbidden = errors.New("forbidden")
 	ErrRevoked = errors.New("revoked")
+	ErrReused = errors.New("refresh token reused")
+	ErrInvalidScope = errors.New("invalid scope")
+	ErrSessionLimit = errors.New("session limit reached")
+	ErrConflict = errors.New("conflict")
 )
\ No newline at end of file
@@ -0,0 +1,41 @@
+package entities
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type OutboxStatus string
+
+const (
+	//waiting for delivery or for the next attempt
+	OutboxPending   OutboxStatus = "pending"
+	OutboxDelivered OutboxStatus = "delivered"
+	//every attempt failed, delivery is given up
+	OutboxDead OutboxStatus = "dead"
+)
+
+// webhook written to the outbox in the transaction of the change it reports, and delivered after commit;
+// an event is written once per subscription receiving it, so every subscription gets it independently
+type OutboxMessage struct {
+	ID uuid.UUID
+	//the same for every subscription the event is delivered to
+	EventID uuid.UUID
+	Event   EventType
+	//user the event is about
+	UserID uuid.UUID
+	//set by ClaimDue
+	Subscription *WebhookSubscription
+	//EventEnvelope
+	Payload json.RawMessage
+	//W3C trace context of the request that produced the message, so its delivery joins the same trace
+	TraceContext  map[string]string
+	Status        OutboxStatus
+	Attempts      int
+	NextAttemptAt time.Time
+	LastError     string
+	CreatedAt     time.Time
+	DeliveredAt   *time.Time
+}
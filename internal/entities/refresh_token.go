@@ -15,4 +15,51 @@ type RefreshToken struct {
 	UserAgent string
 	IPAddress string
 	Revoked bool
-}
\ No newline at end of file
+	//session that replaced this one during rotation; nil if it was never rotated
+	ReplacedBy *uuid.UUID
+	//ID of the first session in rotation chain; all rotated sessions share it
+	FamilyID uuid.UUID
+	//client that issued the session; empty for sessions issued before clients were introduced
+	ClientID string
+	//sha256 of refresh token, identifies session by refresh token alone; empty for sessions issued before it was introduced
+	LookupHash string
+	//space-delimited OAuth scopes granted to the session
+	Scope string
+	//time the user authenticated at; rotated sessions keep the one of the first session
+	AuthTime time.Time
+	//last time session was refreshed or its access token was used; updated with a precision of SessionTouchInterval
+	LastUsedAt time.Time
+}
+
+// what happens to a new session of the user who already has the maximum number of sessions
+type SessionLimitPolicy string
+
+const (
+	//new session is refused with ErrSessionLimit
+	SessionLimitReject SessionLimitPolicy = "reject"
+	//session the user logged in with earliest is revoked
+	SessionLimitEvictOldest SessionLimitPolicy = "evict_oldest"
+	//session used least recently is revoked
+	SessionLimitEvictLRU SessionLimitPolicy = "evict_lru"
+)
+
+// LastUsedAt isn't updated more often than that, so every authenticated request doesn't end up in a write
+const SessionTouchInterval = time.Minute
+
+// fields sessions can be sorted by
+const (
+	SessionSortIssuedAt  = "issued_at"
+	SessionSortExpiresAt = "expires_at"
+)
+
+// page of user's sessions to list
+type SessionListParams struct {
+	Limit  int
+	Offset int
+	//one of SessionSort* constants
+	SortBy string
+	Desc   bool
+	//sessions authenticated or last used at or before these moments are past their deadlines and aren't listed; zero means no limit
+	AuthTimeAfter time.Time
+	LastUsedAfter time.Time
+}
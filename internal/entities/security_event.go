@@ -0,0 +1,145 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// version of the webhook payload schema, docs/webhooks/security-event.v1.schema.json;
+// bumped on any change receivers may break on, fields may be added within a version
+const EventSchemaVersion = "1"
+
+type EventType string
+
+const (
+	EventSessionIssued     EventType = "session.issued"
+	EventSessionIPChanged  EventType = "session.ip_changed"
+	EventSessionLoggedOut  EventType = "session.logged_out"
+	EventSessionRevoked    EventType = "session.revoked"
+	EventUserAgentMismatch EventType = "session.user_agent_mismatch"
+	EventTokenReused       EventType = "refresh_token.reused"
+	EventExpiredRefresh    EventType = "refresh_token.expired"
+	EventSessionsRevoked   EventType = "sessions.revoked_all"
+)
+
+var EventTypes = []EventType{
+	EventSessionIssued, EventSessionIPChanged, EventSessionLoggedOut, EventSessionRevoked, EventUserAgentMismatch,
+	EventTokenReused, EventExpiredRefresh, EventSessionsRevoked,
+}
+
+// security event reported to webhooks; fields of the implementation are the data of the event
+type Event interface {
+	Type() EventType
+	//user the event is about
+	Subject() uuid.UUID
+}
+
+// payload of every webhook
+type EventEnvelope struct {
+	SchemaVersion string    `json:"schema_version"`
+	ID            uuid.UUID `json:"id"`
+	Type          EventType `json:"type"`
+	OccurredAt    time.Time `json:"occurred_at"`
+	UserID        uuid.UUID `json:"user_id"`
+	Data          Event     `json:"data"`
+}
+
+// login or authorization code exchange, not rotation
+type SessionIssued struct {
+	UserID    uuid.UUID `json:"-"`
+	SessionID uuid.UUID `json:"session_id"`
+	ClientID  string    `json:"client_id"`
+	IP        string    `json:"ip"`
+	UserAgent string    `json:"user_agent"`
+}
+
+// session is refreshed from another IP
+type SessionIPChanged struct {
+	UserID uuid.UUID `json:"-"`
+	//session replacing the refreshed one
+	SessionID         uuid.UUID `json:"session_id"`
+	PreviousSessionID uuid.UUID `json:"previous_session_id"`
+	OldIP             string    `json:"old_ip"`
+	NewIP             string    `json:"new_ip"`
+}
+
+type SessionLoggedOut struct {
+	UserID    uuid.UUID `json:"-"`
+	SessionID uuid.UUID `json:"session_id"`
+}
+
+// who asks for a single session to be revoked
+const (
+	//user, with the session API
+	RevokedByUser = "user"
+	//client the session is issued to, with token revocation (RFC 7009)
+	RevokedByClient = "client"
+)
+
+// single session is revoked at request, not on logout
+type SessionRevoked struct {
+	UserID    uuid.UUID `json:"-"`
+	SessionID uuid.UUID `json:"session_id"`
+	ClientID  string    `json:"client_id"`
+	//one of RevokedBy* constants
+	RevokedBy string `json:"revoked_by"`
+}
+
+// session is revoked as it's used with another User-Agent
+type UserAgentMismatch struct {
+	UserID            uuid.UUID `json:"-"`
+	SessionID         uuid.UUID `json:"session_id"`
+	IP                string    `json:"ip"`
+	ExpectedUserAgent string    `json:"expected_user_agent"`
+	UserAgent         string    `json:"user_agent"`
+}
+
+// refresh token of a rotated session is presented again, the whole rotation chain is revoked
+type TokenReused struct {
+	UserID    uuid.UUID `json:"-"`
+	SessionID uuid.UUID `json:"session_id"`
+	FamilyID  uuid.UUID `json:"family_id"`
+	IP        string    `json:"ip"`
+}
+
+// why a session can't be refreshed any longer
+const (
+	ExpiryRefreshTokenTTL = "refresh_token_ttl"
+	ExpiryMaxLifetime     = "max_lifetime"
+	ExpiryIdleTimeout     = "idle_timeout"
+)
+
+// refresh of an expired session is attempted, the session is revoked
+type ExpiredRefresh struct {
+	UserID    uuid.UUID `json:"-"`
+	SessionID uuid.UUID `json:"session_id"`
+	IP        string    `json:"ip"`
+	//one of Expiry* constants
+	Reason string `json:"reason"`
+}
+
+// every session of the user is revoked, but the current one if KeptCurrent
+type SessionsRevoked struct {
+	UserID uuid.UUID `json:"-"`
+	//session the revocation is requested from
+	SessionID   uuid.UUID `json:"session_id"`
+	KeptCurrent bool      `json:"kept_current"`
+}
+
+func (e SessionIssued) Type() EventType        { return EventSessionIssued }
+func (e SessionIPChanged) Type() EventType     { return EventSessionIPChanged }
+func (e SessionLoggedOut) Type() EventType     { return EventSessionLoggedOut }
+func (e SessionRevoked) Type() EventType       { return EventSessionRevoked }
+func (e UserAgentMismatch) Type() EventType    { return EventUserAgentMismatch }
+func (e TokenReused) Type() EventType          { return EventTokenReused }
+func (e ExpiredRefresh) Type() EventType       { return EventExpiredRefresh }
+func (e SessionsRevoked) Type() EventType      { return EventSessionsRevoked }
+func (e SessionIssued) Subject() uuid.UUID     { return e.UserID }
+func (e SessionIPChanged) Subject() uuid.UUID  { return e.UserID }
+func (e SessionLoggedOut) Subject() uuid.UUID  { return e.UserID }
+func (e SessionRevoked) Subject() uuid.UUID    { return e.UserID }
+func (e UserAgentMismatch) Subject() uuid.UUID { return e.UserID }
+func (e TokenReused) Subject() uuid.UUID       { return e.UserID }
+func (e ExpiredRefresh) Subject() uuid.UUID    { return e.UserID }
+func (e SessionsRevoked) Subject() uuid.UUID   { return e.UserID }
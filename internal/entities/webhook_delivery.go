@@ -0,0 +1,54 @@
+package entities
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+type DeliveryStatus string
+
+const (
+	//2xx response
+	DeliverySucceeded DeliveryStatus = "succeeded"
+	//any other response or no response at all
+	DeliveryFailed DeliveryStatus = "failed"
+)
+
+// longer response bodies are truncated in WebhookDelivery
+const MaxDeliveryResponseBody = 4 << 10
+
+// single attempt to deliver an outbox message, kept for inspection and replay
+type WebhookDelivery struct {
+	ID uuid.UUID
+	//message the attempt delivered; it may be purged already
+	OutboxID       uuid.UUID
+	SubscriptionID uuid.UUID
+	EventID        uuid.UUID
+	Event          EventType
+	UserID         uuid.UUID
+	//1 for the first attempt of the message
+	Attempt        int
+	Status         DeliveryStatus
+	RequestURL     string
+	RequestHeaders map[string]string
+	RequestBody    string
+	//0 if no response was received
+	ResponseStatus int
+	//first MaxDeliveryResponseBody bytes
+	ResponseBody string
+	Latency      time.Duration
+	Error        string
+	CreatedAt    time.Time
+}
+
+// page of deliveries to list, newest first; only the ones matching every non-empty filter are listed
+type WebhookDeliveryListParams struct {
+	Limit          int
+	Offset         int
+	UserID         *uuid.UUID
+	EventID        *uuid.UUID
+	Event          EventType
+	SubscriptionID *uuid.UUID
+	Status         DeliveryStatus
+}
@@ -0,0 +1,55 @@
+package entities
+
+import (
+	"slices"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// how events are sent to a subscription
+type WebhookFormat string
+
+const (
+	//session.ip_changed as {"user_id","old_ip","new_ip"} the way WEBHOOK_URL got it, other events as EventEnvelope
+	WebhookFormatLegacy WebhookFormat = "legacy"
+	//CloudEvents 1.0 structured mode: event attributes and data in the body, application/cloudevents+json
+	WebhookFormatCloudEventsStructured WebhookFormat = "cloudevents_structured"
+	//CloudEvents 1.0 binary mode: event attributes in ce-* headers, data as the body
+	WebhookFormatCloudEventsBinary WebhookFormat = "cloudevents_binary"
+)
+
+var WebhookFormats = []WebhookFormat{WebhookFormatLegacy, WebhookFormatCloudEventsStructured, WebhookFormatCloudEventsBinary}
+
+// endpoint security events are delivered to
+type WebhookSubscription struct {
+	ID   uuid.UUID
+	Name string
+	URL  string
+	//HMAC key webhooks are signed with
+	Secret string
+	//events delivered to the subscription; every event if empty
+	EventTypes []EventType
+	Format     WebhookFormat
+	//disabled subscription gets no new events, the ones already queued for it wait until it's enabled
+	Enabled   bool
+	CreatedAt time.Time
+	UpdatedAt time.Time
+	Stats     WebhookStats
+}
+
+// delivery statistics of a subscription
+type WebhookStats struct {
+	Delivered int64
+	//failed attempts, retried ones included
+	FailedAttempts int64
+	//events given up after the last attempt failed
+	Dead            int64
+	LastDeliveredAt *time.Time
+	LastFailedAt    *time.Time
+	LastError       string
+}
+
+func (ws *WebhookSubscription) Accepts(eventType EventType) bool {
+	return len(ws.EventTypes) == 0 || slices.Contains(ws.EventTypes, eventType)
+}
@@ -15,7 +15,13 @@ func MustInitNewPool(cfg config.AppCfg) *pgxpool.Pool {
 	" dbname=" + cfg.PostgresDB + 
 	" port=" + cfg.PostgresPort
 
-	pool, err := pgxpool.New(context.Background(), dsn) 
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		panic(err)
+	}
+	poolCfg.ConnConfig.Tracer = NewQueryTracer()
+
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
 		panic(err)
 	}
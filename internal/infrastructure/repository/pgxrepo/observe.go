@@ -0,0 +1,25 @@
+package pgxrepo
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/superdumb33/auth-service-test/internal/metrics"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/superdumb33/auth-service-test/internal/infrastructure/repository/pgxrepo")
+
+// starts span of PgxAuthRepo operation op; returned func ends it and records duration of op in metrics.
+// Queries made within op are traced as its children by database.QueryTracer
+func observe(ctx context.Context, op string) (context.Context, func()) {
+	start := time.Now()
+	ctx, span := tracer.Start(ctx, strings.Replace(op, "repo:", "PgxAuthRepo.", 1), trace.WithSpanKind(trace.SpanKindInternal))
+
+	return ctx, func() {
+		span.End()
+		metrics.ObserveQuery(op, start)
+	}
+}
@@ -3,6 +3,8 @@ package pgxrepo
 import (
 	"context"
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
@@ -16,6 +18,11 @@ var (
 	ErrInternal = entities.ErrInternal
 )
 
+// columns scanned by scanToken, in order
+const tokenColumns = `id, user_id, token_hash, issued_at, expires_at, user_agent, ip_address, revoked, replaced_by, family_id,
+	COALESCE(client_id, ''), scope, auth_time, last_used_at`
+
+// every method is traced and records its duration in metrics.DBQueryDuration, labeled with its name
 type PgxAuthRepo struct {
 	db *pgxpool.Pool
 }
@@ -26,23 +33,68 @@ func NewPgxAuthRepo(db *pgxpool.Pool) *PgxAuthRepo {
 
 func (ar *PgxAuthRepo) Create(ctx context.Context, rt *entities.RefreshToken) error {
 	const op = "repo:Create"
-	query := `INSERT INTO refresh_tokens (user_id, token_hash, issued_at, expires_at, user_agent, ip_address)
-	VALUES ($1, $2, $3, $4, $5, $6) RETURNING id`
+	ctx, done := observe(ctx, op)
+	defer done()
+	//session without family starts a new one, so its family_id is its own id
+	query := `INSERT INTO refresh_tokens (id, user_id, token_hash, issued_at, expires_at, user_agent, ip_address, family_id, client_id,
+		lookup_hash, scope, auth_time)
+	SELECT new.id, $1, $2, $3, $4, $5, $6, COALESCE($7::uuid, new.id), NULLIF($8, ''), NULLIF($9, ''), $10, COALESCE($11::timestamptz, $3::timestamptz)
+	FROM (SELECT gen_random_uuid() AS id) AS new
+	RETURNING id, family_id, auth_time, last_used_at`
 
-	if err := ar.db.QueryRow(ctx, query, rt.UserID, rt.Hash, rt.IssuedAt, rt.ExpiresAt, rt.UserAgent, rt.IPAddress).Scan(&rt.ID); err != nil {
+	var familyID *uuid.UUID
+	if rt.FamilyID != uuid.Nil {
+		familyID = &rt.FamilyID
+	}
+	//session without auth time is a fresh authentication
+	var authTime *time.Time
+	if !rt.AuthTime.IsZero() {
+		authTime = &rt.AuthTime
+	}
+	if err := conn(ctx, ar.db).QueryRow(ctx, query, rt.UserID, rt.Hash, rt.IssuedAt, rt.ExpiresAt, rt.UserAgent, rt.IPAddress, familyID, rt.ClientID,
+		rt.LookupHash, rt.Scope, authTime).Scan(&rt.ID, &rt.FamilyID, &rt.AuthTime, &rt.LastUsedAt); err != nil {
 		return fmt.Errorf("%s:%w", op, err)
 	}
 
 	return nil
 }
 
+func (ar *PgxAuthRepo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return inTx(ctx, ar.db, fn)
+}
+
 func (ar *PgxAuthRepo) GetTokenByID(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error) {
 	const op = "repo:GetTokenByID"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `SELECT ` + tokenColumns + ` FROM refresh_tokens WHERE id = $1`
+
+	return ar.getToken(ctx, op, query, id)
+}
+
+func (ar *PgxAuthRepo) GetTokenByLookupHash(ctx context.Context, lookupHash string) (*entities.RefreshToken, error) {
+	const op = "repo:GetTokenByLookupHash"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `SELECT ` + tokenColumns + ` FROM refresh_tokens WHERE lookup_hash = $1`
+
+	return ar.getToken(ctx, op, query, lookupHash)
+}
+
+// locks session row until the end of transaction; concurrent callers wait and see the row as it was committed.
+// must be called within InTx
+func (ar *PgxAuthRepo) GetTokenByIDForUpdate(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error) {
+	const op = "repo:GetTokenByIDForUpdate"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `SELECT ` + tokenColumns + ` FROM refresh_tokens WHERE id = $1 FOR UPDATE`
+
+	return ar.getToken(ctx, op, query, id)
+}
+
+func (ar *PgxAuthRepo) getToken(ctx context.Context, op, query string, args ...any) (*entities.RefreshToken, error) {
 	var token entities.RefreshToken
-	query := `SELECT id, user_id, token_hash, issued_at, expires_at, user_agent, ip_address, revoked 
-	FROM refresh_tokens WHERE id = $1`
-	err := ar.db.QueryRow(ctx, query, id).Scan(&token.ID, &token.UserID, &token.Hash, &token.IssuedAt, &token.ExpiresAt, &token.UserAgent, &token.IPAddress, &token.Revoked)
-	if err != nil {
+	if err := scanToken(conn(ctx, ar.db).QueryRow(ctx, query, args...), &token); err != nil {
 		if err == pgx.ErrNoRows {
 			return nil, fmt.Errorf("%s:%w", op, ErrNotFound)
 		}
@@ -52,10 +104,133 @@ func (ar *PgxAuthRepo) GetTokenByID(ctx context.Context, id uuid.UUID) (*entitie
 	return &token, nil
 }
 
+// columns sessions are evicted by, the first one goes first
+var evictionOrder = map[entities.SessionLimitPolicy]string{
+	entities.SessionLimitEvictOldest: "auth_time",
+	entities.SessionLimitEvictLRU:    "last_used_at",
+}
+
+// makes room for one more session of the user, so there are at most limit active sessions after it's created.
+// must be called within InTx along with Create: transaction-level advisory lock on the user serializes concurrent logins
+// until commit, so they can't exceed the limit together
+func (ar *PgxAuthRepo) EnforceSessionLimit(ctx context.Context, userID uuid.UUID, limit int, policy entities.SessionLimitPolicy) error {
+	const op = "repo:EnforceSessionLimit"
+	ctx, done := observe(ctx, op)
+	defer done()
+	db := conn(ctx, ar.db)
+	if _, err := db.Exec(ctx, `SELECT pg_advisory_xact_lock(hashtextextended($1::text, 0))`, userID); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	var active int
+	query := `SELECT count(*) FROM refresh_tokens WHERE user_id = $1 AND NOT revoked AND expires_at > now()`
+	if err := db.QueryRow(ctx, query, userID).Scan(&active); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if active < limit {
+		return nil
+	}
+
+	column, ok := evictionOrder[policy]
+	if !ok {
+		return fmt.Errorf("%s:%w", op, entities.ErrSessionLimit)
+	}
+	query = `UPDATE refresh_tokens SET revoked = true WHERE id IN (
+		SELECT id FROM refresh_tokens WHERE user_id = $1 AND NOT revoked AND expires_at > now()
+		ORDER BY ` + column + `, id LIMIT $2
+	)`
+	if _, err := db.Exec(ctx, query, userID, active-limit+1); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// updates LastUsedAt of the session
+func (ar *PgxAuthRepo) Touch(ctx context.Context, id uuid.UUID) error {
+	const op = "repo:Touch"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `UPDATE refresh_tokens SET last_used_at = now() WHERE id = $1`
+	if _, err := conn(ctx, ar.db).Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// ORDER BY clauses for entities.SessionSort* values; sort field never gets into query as is
+var sessionSortColumns = map[string]string{
+	entities.SessionSortIssuedAt:  "issued_at",
+	entities.SessionSortExpiresAt: "expires_at",
+}
+
+// returns page of user's sessions that are neither revoked nor expired nor past deadlines of params, along with their total number
+func (ar *PgxAuthRepo) ListActiveByUserID(ctx context.Context, userID uuid.UUID, params entities.SessionListParams) ([]*entities.RefreshToken, int, error) {
+	const op = "repo:ListActiveByUserID"
+	ctx, done := observe(ctx, op)
+	defer done()
+	column, ok := sessionSortColumns[params.SortBy]
+	if !ok {
+		return nil, 0, fmt.Errorf("%s:%w", op, entities.ErrBadRequest)
+	}
+	direction := " ASC"
+	if params.Desc {
+		direction = " DESC"
+	}
+	filter := `WHERE user_id = $1 AND NOT revoked AND expires_at > now()`
+	args := []interface{}{userID}
+	if !params.AuthTimeAfter.IsZero() {
+		args = append(args, params.AuthTimeAfter)
+		filter += ` AND auth_time > $` + strconv.Itoa(len(args))
+	}
+	if !params.LastUsedAfter.IsZero() {
+		args = append(args, params.LastUsedAfter)
+		filter += ` AND last_used_at > $` + strconv.Itoa(len(args))
+	}
+
+	var total int
+	if err := conn(ctx, ar.db).QueryRow(ctx, `SELECT count(*) FROM refresh_tokens `+filter, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	//id makes the order stable for sessions issued at the same moment
+	args = append(args, params.Limit, params.Offset)
+	query := `SELECT ` + tokenColumns + ` FROM refresh_tokens ` + filter + `
+	ORDER BY ` + column + direction + `, id` + direction + ` LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	rows, err := conn(ctx, ar.db).Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+	defer rows.Close()
+
+	sessions := make([]*entities.RefreshToken, 0, params.Limit)
+	for rows.Next() {
+		var session entities.RefreshToken
+		if err := scanToken(rows, &session); err != nil {
+			return nil, 0, fmt.Errorf("%s:%w", op, err)
+		}
+		sessions = append(sessions, &session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return sessions, total, nil
+}
+
+func scanToken(row pgx.Row, token *entities.RefreshToken) error {
+	return row.Scan(&token.ID, &token.UserID, &token.Hash, &token.IssuedAt, &token.ExpiresAt, &token.UserAgent, &token.IPAddress,
+		&token.Revoked, &token.ReplacedBy, &token.FamilyID, &token.ClientID, &token.Scope,
+		&token.AuthTime, &token.LastUsedAt)
+}
+
 func (ar *PgxAuthRepo) Revoke(ctx context.Context, id uuid.UUID) error {
 	const op = "repo:Revoke"
+	ctx, done := observe(ctx, op)
+	defer done()
 	query := `UPDATE refresh_tokens SET revoked = true WHERE id=$1`
-	tag, err := ar.db.Exec(ctx, query, id)
+	tag, err := conn(ctx, ar.db).Exec(ctx, query, id)
 	if tag.RowsAffected() == 0 {
 		return fmt.Errorf("%s:%w", op, ErrNotFound)
 	}
@@ -65,8 +240,10 @@ func (ar *PgxAuthRepo) Revoke(ctx context.Context, id uuid.UUID) error {
 
 func (ar *PgxAuthRepo) RevokeAllByUserID (ctx context.Context, userID uuid.UUID) error {
 	const op = "repo:RevokeAllByUserID"
+	ctx, done := observe(ctx, op)
+	defer done()
 	query := `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1`
-	tag, err := ar.db.Exec(ctx, query, userID)
+	tag, err := conn(ctx, ar.db).Exec(ctx, query, userID)
 	if tag.RowsAffected() == 0{
 		return fmt.Errorf("%s:%w", op, ErrNotFound)
 	}
@@ -75,4 +252,45 @@ func (ar *PgxAuthRepo) RevokeAllByUserID (ctx context.Context, userID uuid.UUID)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+func (ar *PgxAuthRepo) RevokeOthersByUserID(ctx context.Context, userID, keepID uuid.UUID) error {
+	const op = "repo:RevokeOthersByUserID"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `UPDATE refresh_tokens SET revoked = true WHERE user_id = $1 AND id <> $2 AND NOT revoked`
+	if _, err := conn(ctx, ar.db).Exec(ctx, query, userID, keepID); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// revokes rotated session and links it to the session that replaced it
+func (ar *PgxAuthRepo) MarkReplaced(ctx context.Context, id, replacedBy uuid.UUID) error {
+	const op = "repo:MarkReplaced"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `UPDATE refresh_tokens SET revoked = true, replaced_by = $2 WHERE id = $1`
+	tag, err := conn(ctx, ar.db).Exec(ctx, query, id, replacedBy)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", op, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (ar *PgxAuthRepo) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	const op = "repo:RevokeFamily"
+	ctx, done := observe(ctx, op)
+	defer done()
+	query := `UPDATE refresh_tokens SET revoked = true WHERE family_id = $1 AND NOT revoked`
+	if _, err := conn(ctx, ar.db).Exec(ctx, query, familyID); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
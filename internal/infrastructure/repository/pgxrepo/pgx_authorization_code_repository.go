@@ -0,0 +1,71 @@
+package pgxrepo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+type PgxAuthorizationCodeRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxAuthorizationCodeRepo(db *pgxpool.Pool) *PgxAuthorizationCodeRepo {
+	return &PgxAuthorizationCodeRepo{db: db}
+}
+
+func (cr *PgxAuthorizationCodeRepo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return inTx(ctx, cr.db, fn)
+}
+
+func (cr *PgxAuthorizationCodeRepo) Create(ctx context.Context, code *entities.AuthorizationCode) error {
+	const op = "repo:CreateAuthorizationCode"
+	query := `INSERT INTO authorization_codes (code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method,
+		nonce, auth_time, issued_at, expires_at)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11)`
+	_, err := conn(ctx, cr.db).Exec(ctx, query, code.CodeHash, code.ClientID, code.UserID, code.RedirectURI, code.Scope,
+		code.CodeChallenge, code.CodeChallengeMethod, code.Nonce, code.AuthTime, code.IssuedAt, code.ExpiresAt)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// locks the code until the end of transaction, so it can be exchanged only once; must be called within InTx
+func (cr *PgxAuthorizationCodeRepo) GetByHashForUpdate(ctx context.Context, codeHash string) (*entities.AuthorizationCode, error) {
+	const op = "repo:GetAuthorizationCodeForUpdate"
+	var code entities.AuthorizationCode
+	query := `SELECT code_hash, client_id, user_id, redirect_uri, scope, code_challenge, code_challenge_method, nonce, auth_time,
+		issued_at, expires_at, used_at, session_id
+	FROM authorization_codes WHERE code_hash = $1 FOR UPDATE`
+	err := conn(ctx, cr.db).QueryRow(ctx, query, codeHash).Scan(&code.CodeHash, &code.ClientID, &code.UserID, &code.RedirectURI,
+		&code.Scope, &code.CodeChallenge, &code.CodeChallengeMethod, &code.Nonce, &code.AuthTime, &code.IssuedAt, &code.ExpiresAt,
+		&code.UsedAt, &code.SessionID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("%s:%w", op, ErrNotFound)
+		}
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return &code, nil
+}
+
+func (cr *PgxAuthorizationCodeRepo) MarkUsed(ctx context.Context, codeHash string, sessionID uuid.UUID) error {
+	const op = "repo:MarkAuthorizationCodeUsed"
+	query := `UPDATE authorization_codes SET used_at = now(), session_id = $2 WHERE code_hash = $1`
+	tag, err := conn(ctx, cr.db).Exec(ctx, query, codeHash, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", op, ErrNotFound)
+	}
+
+	return nil
+}
@@ -0,0 +1,54 @@
+package pgxrepo
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+type PgxClientRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxClientRepo(db *pgxpool.Pool) *PgxClientRepo {
+	return &PgxClientRepo{db: db}
+}
+
+func (cr *PgxClientRepo) GetClientByID(ctx context.Context, id string) (*entities.Client, error) {
+	const op = "repo:GetClientByID"
+	var client entities.Client
+	query := `SELECT id, name, COALESCE(secret_hash, ''), COALESCE(public_key, ''), allow_any_user, allowed_user_ids,
+		allowed_scopes, redirect_uris, created_at
+	FROM clients WHERE id = $1`
+	err := conn(ctx, cr.db).QueryRow(ctx, query, id).Scan(&client.ID, &client.Name, &client.SecretHash, &client.PublicKey,
+		&client.AllowAnyUser, &client.AllowedUserIDs, &client.AllowedScopes, &client.RedirectURIs, &client.CreatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("%s:%w", op, ErrNotFound)
+		}
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return &client, nil
+}
+
+// returns ErrDuplicate if assertion with the same jti was already used by the client
+func (cr *PgxClientRepo) SaveAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	const op = "repo:SaveAssertion"
+	query := `INSERT INTO client_assertions (client_id, jti, expires_at) VALUES ($1, $2, $3)`
+	if _, err := conn(ctx, cr.db).Exec(ctx, query, clientID, jti, expiresAt); err != nil {
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+			return fmt.Errorf("%s:%w", op, ErrDuplicate)
+		}
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,42 @@
+package pgxrepo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+type PgxHealthRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxHealthRepo(db *pgxpool.Pool) *PgxHealthRepo {
+	return &PgxHealthRepo{db: db}
+}
+
+func (hr *PgxHealthRepo) Ping(ctx context.Context) error {
+	const op = "repo:Ping"
+	if err := hr.db.Ping(ctx); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// returns version applied by golang-migrate and whether the last migration failed halfway; ErrNotFound if none was applied
+func (hr *PgxHealthRepo) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	const op = "repo:MigrationVersion"
+	var version int64
+	var dirty bool
+	err := hr.db.QueryRow(ctx, `SELECT version, dirty FROM schema_migrations LIMIT 1`).Scan(&version, &dirty)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return 0, false, fmt.Errorf("%s:%w", op, ErrNotFound)
+		}
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return uint(version), dirty, nil
+}
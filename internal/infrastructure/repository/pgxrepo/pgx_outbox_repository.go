@@ -0,0 +1,150 @@
+package pgxrepo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+const outboxColumns = `o.id, o.event_id, o.event, o.user_id, o.payload, o.trace_context, o.status, o.attempts, o.next_attempt_at, o.last_error,
+	o.created_at, o.delivered_at`
+
+type PgxOutboxRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxOutboxRepo(db *pgxpool.Pool) *PgxOutboxRepo {
+	return &PgxOutboxRepo{db: db}
+}
+
+// writes message once for every enabled subscription accepting its event, within the transaction of ctx if there is one,
+// so it's delivered only if the transaction commits; nothing is written if no subscription accepts it
+func (ob *PgxOutboxRepo) Enqueue(ctx context.Context, msg *entities.OutboxMessage) error {
+	const op = "repo:Enqueue"
+	query := `INSERT INTO webhook_outbox (subscription_id, event_id, event, user_id, payload, trace_context)
+	SELECT id, $1, $2, $3, $4, $5 FROM webhook_subscriptions
+	WHERE enabled AND (cardinality(event_types) = 0 OR $2 = ANY(event_types))`
+	traceContext := msg.TraceContext
+	if traceContext == nil {
+		traceContext = map[string]string{}
+	}
+	if _, err := conn(ctx, ob.db).Exec(ctx, query, msg.EventID, string(msg.Event), msg.UserID, msg.Payload, traceContext); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// returns up to limit due pending messages of enabled subscriptions, oldest first, along with their subscriptions,
+// and pushes their next attempt lease ahead, so no other dispatcher picks them up meanwhile; message not marked in time is delivered again
+func (ob *PgxOutboxRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*entities.OutboxMessage, error) {
+	const op = "repo:ClaimDue"
+	query := `UPDATE webhook_outbox o SET next_attempt_at = now() + $2::interval, leased_until = now() + $2::interval
+	FROM webhook_subscriptions s
+	WHERE s.id = o.subscription_id AND o.id IN (
+		SELECT m.id FROM webhook_outbox m JOIN webhook_subscriptions ms ON ms.id = m.subscription_id
+		WHERE m.status = 'pending' AND m.next_attempt_at <= now() AND ms.enabled
+		ORDER BY m.next_attempt_at LIMIT $1 FOR UPDATE OF m SKIP LOCKED
+	)
+	RETURNING ` + outboxColumns + `, s.id, s.name, s.url, s.secret, s.format`
+	rows, err := conn(ctx, ob.db).Query(ctx, query, limit, lease)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	defer rows.Close()
+
+	messages := make([]*entities.OutboxMessage, 0, limit)
+	for rows.Next() {
+		var msg entities.OutboxMessage
+		var sub entities.WebhookSubscription
+		if err := rows.Scan(&msg.ID, &msg.EventID, &msg.Event, &msg.UserID, &msg.Payload, &msg.TraceContext, &msg.Status, &msg.Attempts,
+			&msg.NextAttemptAt, &msg.LastError, &msg.CreatedAt, &msg.DeliveredAt, &sub.ID, &sub.Name, &sub.URL, &sub.Secret,
+			&sub.Format); err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		msg.Subscription = &sub
+		messages = append(messages, &msg)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return messages, nil
+}
+
+// marks message delivered and counts it in statistics of its subscription
+func (ob *PgxOutboxRepo) MarkDelivered(ctx context.Context, id uuid.UUID) error {
+	const op = "repo:MarkDelivered"
+	query := `WITH delivered AS (
+		UPDATE webhook_outbox SET status = 'delivered', attempts = attempts + 1, delivered_at = now(), last_error = '',
+			leased_until = NULL
+		WHERE id = $1 RETURNING subscription_id
+	)
+	UPDATE webhook_subscriptions SET delivered_count = delivered_count + 1, last_delivered_at = now()
+	WHERE id = (SELECT subscription_id FROM delivered)`
+	if _, err := conn(ctx, ob.db).Exec(ctx, query, id); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// records failed attempt in the message and statistics of its subscription;
+// message is retried at nextAttemptAt, or is dead if nextAttemptAt is nil
+func (ob *PgxOutboxRepo) MarkFailed(ctx context.Context, id uuid.UUID, lastError string, nextAttemptAt *time.Time) error {
+	const op = "repo:MarkFailed"
+	query := `WITH failed AS (
+		UPDATE webhook_outbox SET attempts = attempts + 1, last_error = $2,
+			status = CASE WHEN $3::timestamptz IS NULL THEN 'dead' ELSE 'pending' END,
+			next_attempt_at = COALESCE($3::timestamptz, next_attempt_at), leased_until = NULL
+		WHERE id = $1 RETURNING subscription_id, status
+	)
+	UPDATE webhook_subscriptions s SET failed_attempts = failed_attempts + 1,
+		dead_count = dead_count + CASE WHEN failed.status = 'dead' THEN 1 ELSE 0 END,
+		last_failed_at = now(), last_error = $2
+	FROM failed WHERE s.id = failed.subscription_id`
+	if _, err := conn(ctx, ob.db).Exec(ctx, query, id, lastError, nextAttemptAt); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// makes dead or failed message pending and due now, keeping its attempts, so a dead one gets a single attempt more;
+// a dead message is no longer counted as dead in statistics of its subscription.
+// ErrConflict if the message is delivered, not attempted yet or leased by a dispatcher, ErrNotFound if it's purged
+func (ob *PgxOutboxRepo) Requeue(ctx context.Context, id uuid.UUID) error {
+	const op = "repo:Requeue"
+	query := `WITH requeued AS (
+		UPDATE webhook_outbox o SET status = 'pending', next_attempt_at = now()
+		FROM (SELECT id, status FROM webhook_outbox WHERE id = $1 FOR UPDATE) prev
+		WHERE o.id = prev.id AND (prev.status = 'dead' OR (prev.status = 'pending' AND o.attempts > 0))
+			AND (o.leased_until IS NULL OR o.leased_until <= now())
+		RETURNING o.subscription_id, prev.status
+	), undead AS (
+		UPDATE webhook_subscriptions s SET dead_count = dead_count - 1
+		FROM requeued WHERE s.id = requeued.subscription_id AND requeued.status = 'dead'
+	)
+	SELECT count(*) FROM requeued`
+	var requeued int
+	if err := conn(ctx, ob.db).QueryRow(ctx, query, id).Scan(&requeued); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if requeued > 0 {
+		return nil
+	}
+
+	var exists bool
+	if err := conn(ctx, ob.db).QueryRow(ctx, `SELECT EXISTS (SELECT 1 FROM webhook_outbox WHERE id = $1)`, id).Scan(&exists); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if !exists {
+		return fmt.Errorf("%s:%w", op, ErrNotFound)
+	}
+
+	return fmt.Errorf("%s:%w", op, entities.ErrConflict)
+}
@@ -0,0 +1,76 @@
+package pgxrepo
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// deletes rows nobody needs anymore; every method deletes at most limit rows, so a single statement never holds locks for long
+type PgxPurgeRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxPurgeRepo(db *pgxpool.Pool) *PgxPurgeRepo {
+	return &PgxPurgeRepo{db: db}
+}
+
+// deletes sessions expired before the given time, revoked ones included;
+// revoked sessions are kept until then, since presenting their refresh token again is detected as reuse
+func (pr *PgxPurgeRepo) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const op = "repo:DeleteExpiredSessions"
+	query := `DELETE FROM refresh_tokens WHERE id IN (
+		SELECT id FROM refresh_tokens WHERE expires_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED
+	)`
+
+	return pr.delete(ctx, op, query, before, limit)
+}
+
+func (pr *PgxPurgeRepo) DeleteExpiredAuthorizationCodes(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const op = "repo:DeleteExpiredAuthorizationCodes"
+	query := `DELETE FROM authorization_codes WHERE code_hash IN (
+		SELECT code_hash FROM authorization_codes WHERE expires_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED
+	)`
+
+	return pr.delete(ctx, op, query, before, limit)
+}
+
+// deleted assertions can't be replayed anyway, they're expired
+func (pr *PgxPurgeRepo) DeleteExpiredAssertions(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const op = "repo:DeleteExpiredAssertions"
+	query := `DELETE FROM client_assertions WHERE (client_id, jti) IN (
+		SELECT client_id, jti FROM client_assertions WHERE expires_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED
+	)`
+
+	return pr.delete(ctx, op, query, before, limit)
+}
+
+// dead webhooks are kept for inspection
+func (pr *PgxPurgeRepo) DeleteDeliveredWebhooks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const op = "repo:DeleteDeliveredWebhooks"
+	query := `DELETE FROM webhook_outbox WHERE id IN (
+		SELECT id FROM webhook_outbox WHERE status = 'delivered' AND delivered_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED
+	)`
+
+	return pr.delete(ctx, op, query, before, limit)
+}
+
+func (pr *PgxPurgeRepo) DeleteWebhookDeliveries(ctx context.Context, before time.Time, limit int) (int64, error) {
+	const op = "repo:DeleteWebhookDeliveries"
+	query := `DELETE FROM webhook_deliveries WHERE id IN (
+		SELECT id FROM webhook_deliveries WHERE created_at < $1 LIMIT $2 FOR UPDATE SKIP LOCKED
+	)`
+
+	return pr.delete(ctx, op, query, before, limit)
+}
+
+func (pr *PgxPurgeRepo) delete(ctx context.Context, op, query string, before time.Time, limit int) (int64, error) {
+	tag, err := conn(ctx, pr.db).Exec(ctx, query, before, limit)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
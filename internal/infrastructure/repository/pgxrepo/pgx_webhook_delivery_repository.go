@@ -0,0 +1,144 @@
+package pgxrepo
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+const deliveryColumns = `id, outbox_id, subscription_id, event_id, event, user_id, attempt, status, request_url, request_headers,
+	request_body, response_status, response_body, latency_ms, error, created_at`
+
+type PgxWebhookDeliveryRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxWebhookDeliveryRepo(db *pgxpool.Pool) *PgxWebhookDeliveryRepo {
+	return &PgxWebhookDeliveryRepo{db: db}
+}
+
+func (dr *PgxWebhookDeliveryRepo) Record(ctx context.Context, delivery *entities.WebhookDelivery) error {
+	const op = "repo:RecordWebhookDelivery"
+	query := `INSERT INTO webhook_deliveries (outbox_id, subscription_id, event_id, event, user_id, attempt, status, request_url,
+		request_headers, request_body, response_status, response_body, latency_ms, error)
+	VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+	RETURNING id, created_at`
+	var responseStatus *int
+	if delivery.ResponseStatus != 0 {
+		responseStatus = &delivery.ResponseStatus
+	}
+	headers := delivery.RequestHeaders
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	err := conn(ctx, dr.db).QueryRow(ctx, query, delivery.OutboxID, delivery.SubscriptionID, delivery.EventID, string(delivery.Event),
+		delivery.UserID, delivery.Attempt, string(delivery.Status), delivery.RequestURL, headers, delivery.RequestBody, responseStatus,
+		delivery.ResponseBody, delivery.Latency.Milliseconds(), delivery.Error).Scan(&delivery.ID, &delivery.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+func (dr *PgxWebhookDeliveryRepo) GetByID(ctx context.Context, id uuid.UUID) (*entities.WebhookDelivery, error) {
+	const op = "repo:GetWebhookDeliveryByID"
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE id = $1`
+	delivery, err := scanDelivery(conn(ctx, dr.db).QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("%s:%w", op, ErrNotFound)
+		}
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return delivery, nil
+}
+
+// returns page of deliveries matching params, newest first, and the number of matching ones across all pages
+func (dr *PgxWebhookDeliveryRepo) List(ctx context.Context, params entities.WebhookDeliveryListParams) ([]*entities.WebhookDelivery, int, error) {
+	const op = "repo:ListWebhookDeliveries"
+	var (
+		conditions []string
+		args       []interface{}
+	)
+	where := func(column string, value interface{}) {
+		args = append(args, value)
+		conditions = append(conditions, column+" $"+strconv.Itoa(len(args)))
+	}
+	if params.UserID != nil {
+		where("user_id =", *params.UserID)
+	}
+	if params.EventID != nil {
+		where("event_id =", *params.EventID)
+	}
+	if params.Event != "" {
+		where("event =", string(params.Event))
+	}
+	if params.SubscriptionID != nil {
+		where("subscription_id =", *params.SubscriptionID)
+	}
+	if params.Status != "" {
+		where("status =", string(params.Status))
+	}
+	filter := ""
+	if len(conditions) > 0 {
+		filter = ` WHERE ` + strings.Join(conditions, " AND ")
+	}
+
+	var total int
+	if err := conn(ctx, dr.db).QueryRow(ctx, `SELECT count(*) FROM webhook_deliveries`+filter, args...).Scan(&total); err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	//id makes the order stable for attempts made at the same moment
+	args = append(args, params.Limit, params.Offset)
+	query := `SELECT ` + deliveryColumns + ` FROM webhook_deliveries` + filter + `
+	ORDER BY created_at DESC, id DESC LIMIT $` + strconv.Itoa(len(args)-1) + ` OFFSET $` + strconv.Itoa(len(args))
+	rows, err := conn(ctx, dr.db).Query(ctx, query, args...)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+	defer rows.Close()
+
+	deliveries := make([]*entities.WebhookDelivery, 0, params.Limit)
+	for rows.Next() {
+		delivery, err := scanDelivery(rows)
+		if err != nil {
+			return nil, 0, fmt.Errorf("%s:%w", op, err)
+		}
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return deliveries, total, nil
+}
+
+func scanDelivery(row pgx.Row) (*entities.WebhookDelivery, error) {
+	var (
+		delivery       entities.WebhookDelivery
+		responseStatus *int
+		latencyMs      int64
+	)
+	err := row.Scan(&delivery.ID, &delivery.OutboxID, &delivery.SubscriptionID, &delivery.EventID, &delivery.Event, &delivery.UserID,
+		&delivery.Attempt, &delivery.Status, &delivery.RequestURL, &delivery.RequestHeaders, &delivery.RequestBody, &responseStatus,
+		&delivery.ResponseBody, &latencyMs, &delivery.Error, &delivery.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if responseStatus != nil {
+		delivery.ResponseStatus = *responseStatus
+	}
+	delivery.Latency = time.Duration(latencyMs) * time.Millisecond
+
+	return &delivery, nil
+}
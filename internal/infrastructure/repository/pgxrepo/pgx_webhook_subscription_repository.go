@@ -0,0 +1,179 @@
+package pgxrepo
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+const subscriptionColumns = `id, name, url, secret, event_types, format, enabled, created_at, updated_at,
+	delivered_count, failed_attempts, dead_count, last_delivered_at, last_failed_at, last_error`
+
+type PgxWebhookSubscriptionRepo struct {
+	db *pgxpool.Pool
+}
+
+func NewPgxWebhookSubscriptionRepo(db *pgxpool.Pool) *PgxWebhookSubscriptionRepo {
+	return &PgxWebhookSubscriptionRepo{db: db}
+}
+
+func (sr *PgxWebhookSubscriptionRepo) Create(ctx context.Context, sub *entities.WebhookSubscription) error {
+	const op = "repo:CreateWebhookSubscription"
+	query := `INSERT INTO webhook_subscriptions (name, url, secret, event_types, format, enabled) VALUES ($1, $2, $3, $4, $5, $6)
+	RETURNING id, created_at, updated_at`
+	err := conn(ctx, sr.db).QueryRow(ctx, query, sub.Name, sub.URL, sub.Secret, eventTypesToStrings(sub.EventTypes), sub.Format, sub.Enabled).
+		Scan(&sub.ID, &sub.CreatedAt, &sub.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+func (sr *PgxWebhookSubscriptionRepo) GetByID(ctx context.Context, id uuid.UUID) (*entities.WebhookSubscription, error) {
+	const op = "repo:GetWebhookSubscriptionByID"
+	query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions WHERE id = $1`
+	sub, err := scanSubscription(conn(ctx, sr.db).QueryRow(ctx, query, id))
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return nil, fmt.Errorf("%s:%w", op, ErrNotFound)
+		}
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return sub, nil
+}
+
+// returns every subscription, oldest first
+func (sr *PgxWebhookSubscriptionRepo) List(ctx context.Context) ([]*entities.WebhookSubscription, error) {
+	const op = "repo:ListWebhookSubscriptions"
+	query := `SELECT ` + subscriptionColumns + ` FROM webhook_subscriptions ORDER BY created_at`
+	rows, err := conn(ctx, sr.db).Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	defer rows.Close()
+
+	subs := []*entities.WebhookSubscription{}
+	for rows.Next() {
+		sub, err := scanSubscription(rows)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		subs = append(subs, sub)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return subs, nil
+}
+
+// saves settings of the subscription, statistics are left as they are
+func (sr *PgxWebhookSubscriptionRepo) Update(ctx context.Context, sub *entities.WebhookSubscription) error {
+	const op = "repo:UpdateWebhookSubscription"
+	query := `UPDATE webhook_subscriptions SET name = $2, url = $3, secret = $4, event_types = $5, format = $6, enabled = $7,
+	updated_at = now() WHERE id = $1 RETURNING updated_at`
+	err := conn(ctx, sr.db).QueryRow(ctx, query, sub.ID, sub.Name, sub.URL, sub.Secret, eventTypesToStrings(sub.EventTypes), sub.Format,
+		sub.Enabled).
+		Scan(&sub.UpdatedAt)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			return fmt.Errorf("%s:%w", op, ErrNotFound)
+		}
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// deletes subscription along with webhooks queued for it
+func (sr *PgxWebhookSubscriptionRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	const op = "repo:DeleteWebhookSubscription"
+	tag, err := conn(ctx, sr.db).Exec(ctx, `DELETE FROM webhook_subscriptions WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("%s:%w", op, ErrNotFound)
+	}
+
+	return nil
+}
+
+func (sr *PgxWebhookSubscriptionRepo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return inTx(ctx, sr.db, fn)
+}
+
+// keeps subscriptions from being created or changed by others until the end of transaction; reads aren't blocked
+func (sr *PgxWebhookSubscriptionRepo) Lock(ctx context.Context) error {
+	const op = "repo:LockWebhookSubscriptions"
+	if _, err := conn(ctx, sr.db).Exec(ctx, `LOCK TABLE webhook_subscriptions IN SHARE ROW EXCLUSIVE MODE`); err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	return nil
+}
+
+// attaches pending webhooks queued before subscriptions were introduced to subscription id if it takes their event,
+// returns their number; the rest are dropped, as nobody is going to receive them
+func (sr *PgxWebhookSubscriptionRepo) AttachUnsubscribed(ctx context.Context, id uuid.UUID) (int64, error) {
+	const op = "repo:AttachUnsubscribedWebhooks"
+	tag, err := conn(ctx, sr.db).Exec(ctx, `UPDATE webhook_outbox o SET subscription_id = s.id
+	FROM webhook_subscriptions s
+	WHERE s.id = $1 AND o.subscription_id IS NULL AND o.status = 'pending'
+	AND (cardinality(s.event_types) = 0 OR o.event = ANY(s.event_types))`, id)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+	if _, err := conn(ctx, sr.db).Exec(ctx, `DELETE FROM webhook_outbox WHERE subscription_id IS NULL AND status = 'pending'`); err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return tag.RowsAffected(), nil
+}
+
+// returns number of pending webhooks queued before subscriptions were introduced and not attached to any yet
+func (sr *PgxWebhookSubscriptionRepo) CountUnsubscribed(ctx context.Context) (int64, error) {
+	const op = "repo:CountUnsubscribedWebhooks"
+	var count int64
+	err := conn(ctx, sr.db).QueryRow(ctx, `SELECT count(*) FROM webhook_outbox WHERE subscription_id IS NULL AND status = 'pending'`).
+		Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return count, nil
+}
+
+func scanSubscription(row pgx.Row) (*entities.WebhookSubscription, error) {
+	var (
+		sub        entities.WebhookSubscription
+		eventTypes []string
+	)
+	err := row.Scan(&sub.ID, &sub.Name, &sub.URL, &sub.Secret, &eventTypes, &sub.Format, &sub.Enabled, &sub.CreatedAt, &sub.UpdatedAt,
+		&sub.Stats.Delivered, &sub.Stats.FailedAttempts, &sub.Stats.Dead, &sub.Stats.LastDeliveredAt, &sub.Stats.LastFailedAt,
+		&sub.Stats.LastError)
+	if err != nil {
+		return nil, err
+	}
+	sub.EventTypes = make([]entities.EventType, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		sub.EventTypes = append(sub.EventTypes, entities.EventType(eventType))
+	}
+
+	return &sub, nil
+}
+
+func eventTypesToStrings(eventTypes []entities.EventType) []string {
+	strs := make([]string, 0, len(eventTypes))
+	for _, eventType := range eventTypes {
+		strs = append(strs, string(eventType))
+	}
+
+	return strs
+}
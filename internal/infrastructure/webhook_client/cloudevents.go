@@ -0,0 +1,99 @@
+package webhookclient
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+const cloudEventsSpecVersion = "1.0"
+
+// EventEnvelope as it's stored in the outbox, data is left undecoded
+type storedEnvelope struct {
+	SchemaVersion string             `json:"schema_version"`
+	ID            uuid.UUID          `json:"id"`
+	Type          entities.EventType `json:"type"`
+	OccurredAt    time.Time          `json:"occurred_at"`
+	UserID        uuid.UUID          `json:"user_id"`
+	Data          json.RawMessage    `json:"data"`
+}
+
+// CloudEvents 1.0 event in structured mode; schemaversion is an extension attribute carrying EventSchemaVersion
+type cloudEvent struct {
+	SpecVersion     string          `json:"specversion"`
+	ID              string          `json:"id"`
+	Source          string          `json:"source"`
+	Type            string          `json:"type"`
+	Time            time.Time       `json:"time"`
+	Subject         string          `json:"subject"`
+	DataContentType string          `json:"datacontenttype"`
+	SchemaVersion   string          `json:"schemaversion"`
+	Data            json.RawMessage `json:"data"`
+}
+
+// session.ip_changed in legacy format, the body WEBHOOK_URL got before EventEnvelope
+type legacyIPChange struct {
+	UserID string `json:"user_id"`
+	OldIP  string `json:"old_ip"`
+	NewIP  string `json:"new_ip"`
+}
+
+// returns body and headers the payload of msg is sent with in the format of its subscription;
+// source is the CloudEvents source attribute
+func encode(msg *entities.OutboxMessage, source string) ([]byte, http.Header, error) {
+	header := http.Header{}
+	var envelope storedEnvelope
+	if err := json.Unmarshal(msg.Payload, &envelope); err != nil {
+		return nil, nil, err
+	}
+	switch msg.Subscription.Format {
+	case entities.WebhookFormatLegacy, "":
+		header.Set("Content-Type", "application/json")
+		//other events, and payloads written before EventEnvelope, are sent as is
+		if envelope.Type != entities.EventSessionIPChanged {
+			return msg.Payload, header, nil
+		}
+		var data entities.SessionIPChanged
+		if err := json.Unmarshal(envelope.Data, &data); err != nil {
+			return nil, nil, err
+		}
+		body, err := json.Marshal(legacyIPChange{UserID: envelope.UserID.String(), OldIP: data.OldIP, NewIP: data.NewIP})
+		if err != nil {
+			return nil, nil, err
+		}
+		return body, header, nil
+	case entities.WebhookFormatCloudEventsStructured:
+		body, err := json.Marshal(cloudEvent{
+			SpecVersion:     cloudEventsSpecVersion,
+			ID:              envelope.ID.String(),
+			Source:          source,
+			Type:            string(envelope.Type),
+			Time:            envelope.OccurredAt,
+			Subject:         envelope.UserID.String(),
+			DataContentType: "application/json",
+			SchemaVersion:   envelope.SchemaVersion,
+			Data:            envelope.Data,
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		header.Set("Content-Type", "application/cloudevents+json")
+		return body, header, nil
+	case entities.WebhookFormatCloudEventsBinary:
+		header.Set("Content-Type", "application/json")
+		header.Set("ce-specversion", cloudEventsSpecVersion)
+		header.Set("ce-id", envelope.ID.String())
+		header.Set("ce-source", source)
+		header.Set("ce-type", string(envelope.Type))
+		header.Set("ce-time", envelope.OccurredAt.Format(time.RFC3339Nano))
+		header.Set("ce-subject", envelope.UserID.String())
+		header.Set("ce-schemaversion", envelope.SchemaVersion)
+		return envelope.Data, header, nil
+	default:
+		return nil, nil, fmt.Errorf("unknown webhook format %q", msg.Subscription.Format)
+	}
+}
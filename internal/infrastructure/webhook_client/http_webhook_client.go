@@ -3,56 +3,122 @@ package webhookclient
 import (
 	"bytes"
 	"context"
-	"encoding/json"
+	"fmt"
+	"io"
 	"log/slog"
 	"net/http"
 	"time"
 
-	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
+	"github.com/superdumb33/auth-service-test/pkg/webhook"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
 )
 
+var tracer = otel.Tracer("github.com/superdumb33/auth-service-test/internal/infrastructure/webhook_client")
+
 type Client struct {
-	webhookURL string
-	client     *http.Client
-	log *slog.Logger
+	client *http.Client
+	log    *slog.Logger
+	//CloudEvents source attribute
+	source string
 }
 
-//it'll throw a panic if something goes wrong
-func MustInitNewClient(webhookURL string, log *slog.Logger) *Client {
+// single delivery, response included, takes at most that long
+const Timeout = 10 * time.Second
+
+// source identifies the service in CloudEvents, e.g. its issuer URL
+func NewClient(log *slog.Logger, source string) *Client {
 	client := &http.Client{
-		Timeout: time.Duration(time.Second * 10),
+		Timeout: Timeout,
 	}
 
-	if webhookURL == "" {
-		panic("empty webhookURL")
-	}
-	return &Client{client: client, webhookURL: webhookURL, log: log}
+	return &Client{client: client, log: log, source: source}
 }
 
-func (hc *Client) NotifyIPChange(ctx context.Context, userID uuid.UUID, oldIP, newIP string) {
-	payload := map[string]interface{}{
-		"user_id": userID.String(),
-		"old_ip":  oldIP,
-		"new_ip":  newIP,
-	}
+// posts payload of the message to the URL of its subscription in the format of the subscription,
+// signed with the subscription secret, see pkg/webhook; any response but 2xx is an error.
+// Returns the attempt made, failed ones included, or nil if the request couldn't be made
+func (hc *Client) Deliver(ctx context.Context, msg *entities.OutboxMessage) (*entities.WebhookDelivery, error) {
+	const op = "webhookclient:Deliver"
+	event := string(msg.Event)
+	start := time.Now()
+	result := metrics.WebhookError
+	//span continues the trace of the request that produced the event
+	ctx, span := tracer.Start(ctx, "webhook "+event, trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("webhook.event", event), attribute.String("webhook.subscription_id", msg.Subscription.ID.String()),
+			attribute.String("webhook.format", string(msg.Subscription.Format))))
+	defer func() {
+		if result != metrics.WebhookSuccess {
+			span.SetStatus(codes.Error, result)
+		}
+		span.End()
+		metrics.WebhookDeliveries.WithLabelValues(event, result).Inc()
+		metrics.WebhookDuration.WithLabelValues(event).Observe(time.Since(start).Seconds())
+	}()
 
-	body, err := json.Marshal(payload)
+	body, header, err := encode(msg, hc.source)
 	if err != nil {
-		hc.log.Error("HTTP Client error", "error", err)
+		hc.log.Error("HTTP Client error", "error", err, "subscription_id", msg.Subscription.ID)
+		return nil, fmt.Errorf("%s:%w", op, err)
 	}
-	req, err := http.NewRequestWithContext(ctx, "POST", hc.webhookURL, bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, "POST", msg.Subscription.URL, bytes.NewReader(body))
 	if err != nil {
 		hc.log.Error("HTTP Client error", "error", err)
+		return nil, fmt.Errorf("%s:%w", op, err)
 	}
-	req.Header.Set("Content-Type", "application/json")
+	req.Header = header
+	//every attempt is signed anew, so a retry isn't rejected as stale; the ID stays the same
+	webhook.SignRequest(req, []byte(msg.Subscription.Secret), msg.EventID.String(), time.Now(), body)
+	//receiver may continue the trace from traceparent header
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(req.Header))
 
+	delivery := &entities.WebhookDelivery{
+		OutboxID:       msg.ID,
+		SubscriptionID: msg.Subscription.ID,
+		EventID:        msg.EventID,
+		Event:          msg.Event,
+		UserID:         msg.UserID,
+		Attempt:        msg.Attempts + 1,
+		Status:         entities.DeliveryFailed,
+		RequestURL:     msg.Subscription.URL,
+		RequestHeaders: make(map[string]string, len(req.Header)),
+		RequestBody:    string(body),
+	}
+	for name := range req.Header {
+		delivery.RequestHeaders[name] = req.Header.Get(name)
+	}
+	sent := time.Now()
 	resp, err := hc.client.Do(req)
 	if err != nil {
+		delivery.Latency = time.Since(sent)
+		delivery.Error = err.Error()
+		span.RecordError(err)
 		hc.log.Error("HTTP Client error", "error", err)
+		return delivery, fmt.Errorf("%s:%w", op, err)
 	}
 	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, entities.MaxDeliveryResponseBody))
+	delivery.Latency = time.Since(sent)
+	delivery.ResponseStatus = resp.StatusCode
+	delivery.ResponseBody = string(respBody)
+	//drained body lets the connection be reused
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
 
+	span.SetAttributes(attribute.Int("http.response.status_code", resp.StatusCode))
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		hc.log.Error("HTTP Client error", "error", "unexpected status code returned from webhook", "code", resp.StatusCode)
+		result = metrics.WebhookHTTPError
+		delivery.Error = fmt.Sprintf("unexpected status code %d", resp.StatusCode)
+		hc.log.Error("HTTP Client error", "error", "unexpected status code returned from webhook", "code", resp.StatusCode,
+			"subscription_id", msg.Subscription.ID)
+		return delivery, fmt.Errorf("%s:%s", op, delivery.Error)
 	}
+	result = metrics.WebhookSuccess
+	delivery.Status = entities.DeliverySucceeded
+
+	return delivery, nil
 }
@@ -0,0 +1,145 @@
+package webhookclient_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	webhookclient "github.com/superdumb33/auth-service-test/internal/infrastructure/webhook_client"
+	"github.com/superdumb33/auth-service-test/pkg/webhook"
+)
+
+func TestClient_Deliver(t *testing.T) {
+	const source = "https://auth.example.com"
+	secret := "subscription-secret"
+	eventID, userID := uuid.New(), uuid.New()
+	occurredAt := time.Date(2025, 5, 19, 10, 12, 3, 0, time.UTC)
+	payload, _ := json.Marshal(entities.EventEnvelope{
+		SchemaVersion: entities.EventSchemaVersion,
+		ID:            eventID,
+		Type:          entities.EventSessionIPChanged,
+		OccurredAt:    occurredAt,
+		UserID:        userID,
+		Data:          entities.SessionIPChanged{UserID: userID, OldIP: "10.0.0.1", NewIP: "10.0.0.2"},
+	})
+
+	var (
+		header http.Header
+		body   []byte
+		status = http.StatusOK
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		header = r.Header
+		if body, err = webhook.NewVerifier([][]byte{[]byte(secret)}, 0, nil).VerifyRequest(r); err != nil {
+			t.Errorf("expected signed request: %v", err)
+		}
+		w.WriteHeader(status)
+		w.Write([]byte(strings.Repeat("x", entities.MaxDeliveryResponseBody+100)))
+	}))
+	defer server.Close()
+	client := webhookclient.NewClient(slog.New(slog.NewTextHandler(io.Discard, nil)), source)
+	newMessage := func(format entities.WebhookFormat) *entities.OutboxMessage {
+		return &entities.OutboxMessage{ID: uuid.New(), EventID: eventID, Event: entities.EventSessionIPChanged, UserID: userID,
+			Payload: payload, Attempts: 2,
+			Subscription: &entities.WebhookSubscription{ID: uuid.New(), URL: server.URL, Secret: secret, Format: format}}
+	}
+	deliver := func(t *testing.T, format entities.WebhookFormat) {
+		t.Helper()
+		if _, err := client.Deliver(context.Background(), newMessage(format)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	t.Run("Legacy", func(t *testing.T) {
+		deliver(t, entities.WebhookFormatLegacy)
+		want := `{"user_id":"` + userID.String() + `","old_ip":"10.0.0.1","new_ip":"10.0.0.2"}`
+		if string(body) != want || header.Get("Content-Type") != "application/json" {
+			t.Fatalf("expected IP change the way WEBHOOK_URL got it, got %s %s", header.Get("Content-Type"), body)
+		}
+
+		//other events have no legacy shape
+		reused, _ := json.Marshal(entities.EventEnvelope{SchemaVersion: entities.EventSchemaVersion, ID: eventID,
+			Type: entities.EventTokenReused, OccurredAt: occurredAt, UserID: userID, Data: entities.TokenReused{UserID: userID}})
+		msg := newMessage(entities.WebhookFormatLegacy)
+		msg.Event, msg.Payload = entities.EventTokenReused, reused
+		if _, err := client.Deliver(context.Background(), msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if string(body) != string(reused) {
+			t.Fatalf("expected envelope as is, got %s", body)
+		}
+	})
+
+	t.Run("CloudEvents structured", func(t *testing.T) {
+		deliver(t, entities.WebhookFormatCloudEventsStructured)
+		if header.Get("Content-Type") != "application/cloudevents+json" {
+			t.Fatalf("unexpected content type %s", header.Get("Content-Type"))
+		}
+		var event map[string]interface{}
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		data, _ := event["data"].(map[string]interface{})
+		if event["specversion"] != "1.0" || event["id"] != eventID.String() || event["source"] != source ||
+			event["type"] != string(entities.EventSessionIPChanged) || event["time"] != "2025-05-19T10:12:03Z" ||
+			event["subject"] != userID.String() || data["new_ip"] != "10.0.0.2" {
+			t.Fatalf("unexpected event: %s", body)
+		}
+	})
+
+	t.Run("CloudEvents binary", func(t *testing.T) {
+		deliver(t, entities.WebhookFormatCloudEventsBinary)
+		if header.Get("ce-specversion") != "1.0" || header.Get("ce-id") != eventID.String() || header.Get("ce-source") != source ||
+			header.Get("ce-type") != string(entities.EventSessionIPChanged) || header.Get("ce-time") != "2025-05-19T10:12:03Z" ||
+			header.Get("ce-subject") != userID.String() || header.Get("Content-Type") != "application/json" {
+			t.Fatalf("unexpected headers: %v", header)
+		}
+		var data map[string]interface{}
+		if err := json.Unmarshal(body, &data); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if data["old_ip"] != "10.0.0.1" || data["new_ip"] != "10.0.0.2" || data["user_id"] != nil {
+			t.Fatalf("expected event data as the body, got %s", body)
+		}
+	})
+
+	t.Run("Recorded attempts", func(t *testing.T) {
+		msg := newMessage(entities.WebhookFormatLegacy)
+		delivery, err := client.Deliver(context.Background(), msg)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if delivery.OutboxID != msg.ID || delivery.SubscriptionID != msg.Subscription.ID || delivery.UserID != userID ||
+			delivery.Attempt != 3 || delivery.Status != entities.DeliverySucceeded || delivery.RequestBody != string(body) ||
+			delivery.RequestHeaders[webhook.HeaderID] != eventID.String() || delivery.ResponseStatus != http.StatusOK ||
+			len(delivery.ResponseBody) != entities.MaxDeliveryResponseBody || delivery.Error != "" {
+			t.Fatalf("unexpected delivery: %+v", delivery)
+		}
+
+		status = http.StatusServiceUnavailable
+		defer func() { status = http.StatusOK }()
+		delivery, err = client.Deliver(context.Background(), msg)
+		if err == nil {
+			t.Fatal("expected error")
+		}
+		if delivery == nil || delivery.Status != entities.DeliveryFailed || delivery.ResponseStatus != http.StatusServiceUnavailable ||
+			delivery.Error == "" {
+			t.Fatalf("expected failed attempt to be returned, got %+v", delivery)
+		}
+
+		msg.Subscription.URL = "http://127.0.0.1:1"
+		delivery, err = client.Deliver(context.Background(), msg)
+		if err == nil || delivery == nil || delivery.ResponseStatus != 0 || delivery.Error == "" {
+			t.Fatalf("expected attempt without response, got %+v, %v", delivery, err)
+		}
+	})
+}
@@ -0,0 +1,92 @@
+package jobs
+
+import (
+	"context"
+	"log/slog"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(ctx context.Context) error
+}
+
+// runs background jobs of the app periodically, every job in its own goroutine
+type Scheduler struct {
+	log    *slog.Logger
+	jobs   []job
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(log *slog.Logger) *Scheduler {
+	return &Scheduler{log: log}
+}
+
+// job runs right after Start and then every interval; runs of the same job never overlap.
+// Must be called before Start
+func (s *Scheduler) Add(name string, interval time.Duration, run func(ctx context.Context) error) {
+	s.jobs = append(s.jobs, job{name: name, interval: interval, run: run})
+}
+
+func (s *Scheduler) Start() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(ctx, j)
+	}
+}
+
+// cancels context of running jobs and waits for them to return or for ctx to be done
+func (s *Scheduler) Stop(ctx context.Context) error {
+	if s.cancel == nil {
+		return nil
+	}
+	s.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Scheduler) loop(ctx context.Context, j job) {
+	defer s.wg.Done()
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		s.runOnce(ctx, j)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// panic of a job is logged, so it neither kills the app nor stops later runs
+func (s *Scheduler) runOnce(ctx context.Context, j job) {
+	defer func() {
+		if r := recover(); r != nil {
+			s.log.Error("background job panicked", "job", j.name, "panic", r, "stack", string(debug.Stack()))
+		}
+	}()
+
+	//jobs log their own results, some of them run every second
+	if err := j.run(ctx); err != nil && ctx.Err() == nil {
+		s.log.Error("background job failed", "job", j.name, "error", err)
+	}
+}
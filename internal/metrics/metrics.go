@@ -0,0 +1,140 @@
+// Package metrics holds Prometheus collectors of the service; they're registered in Registry, served at /metrics
+package metrics
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "auth"
+
+// results of a refresh besides the failure reasons below
+const (
+	RefreshSuccess = "success"
+	RefreshError   = "error"
+)
+
+// reasons a refresh fails for
+const (
+	RefreshRevoked        = "revoked"
+	RefreshReused         = "reused"
+	RefreshUAMismatch     = "ua_mismatch"
+	RefreshExpired        = "expired"
+	RefreshSessionExpired = "session_expired"
+	RefreshInvalidToken   = "invalid_token"
+	RefreshUnknownSession = "unknown_session"
+	RefreshClientMismatch = "client_mismatch"
+	RefreshInvalidScope   = "invalid_scope"
+)
+
+// reasons sessions are revoked for
+const (
+	RevocationLogout        = "logout"
+	RevocationRevokeAll     = "revoke_all"
+	RevocationRevokeOthers  = "revoke_others"
+	RevocationRevokeSession = "revoke_session"
+	RevocationOAuthRevoke   = "oauth_revoke"
+	RevocationUAMismatch    = "ua_mismatch"
+	RevocationTokenReuse    = "token_reuse"
+	RevocationCodeReuse     = "code_reuse"
+	RevocationExpired       = "expired"
+	RevocationInvalidToken  = "invalid_token"
+)
+
+// results of a webhook delivery
+const (
+	WebhookSuccess   = "success"
+	WebhookHTTPError = "http_error"
+	WebhookError     = "error"
+)
+
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+var (
+	//flow is login for new sessions and refresh for rotated ones
+	TokensIssued = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_issued_total",
+		Help:      "Token pairs issued.",
+	}, []string{"flow"})
+
+	Refreshes = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "refreshes_total",
+		Help:      "Refresh attempts by result: success, error or failure reason.",
+	}, []string{"result"})
+
+	Revocations = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "revocations_total",
+		Help:      "Revocations of sessions, session families or all sessions of a user, by reason.",
+	}, []string{"reason"})
+
+	AccessTokenRejections = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "access_token_rejections_total",
+		Help:      "Requests rejected by the auth middleware, by reason.",
+	}, []string{"reason"})
+
+	WebhookDeliveries = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "webhook_deliveries_total",
+		Help:      "Webhook deliveries by event and result.",
+	}, []string{"event", "result"})
+
+	WebhookDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "webhook_delivery_duration_seconds",
+		Help:      "Time taken to deliver a webhook, failed deliveries included.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"event"})
+
+	DBQueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "db_query_duration_seconds",
+		Help:      "Time taken by repository operations.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+
+	//route is the route pattern, e.g. /api/v1/auth/sessions/:id, so IDs don't make up new series
+	HTTPRequests = factory.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	HTTPRequestDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time taken to serve HTTP requests.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+)
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+}
+
+// records duration of repository operation op started at start; meant to be deferred, e.g. defer metrics.ObserveQuery(op, time.Now())
+func ObserveQuery(op string, start time.Time) {
+	DBQueryDuration.WithLabelValues(strings.TrimPrefix(op, "repo:")).Observe(time.Since(start).Seconds())
+}
+
+func ObserveHTTPRequest(method, route string, status int, duration time.Duration) {
+	HTTPRequests.WithLabelValues(method, route, strconv.Itoa(status)).Inc()
+	HTTPRequestDuration.WithLabelValues(method, route).Observe(duration.Seconds())
+}
+
+// serves metrics of Registry in Prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
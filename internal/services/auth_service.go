@@ -2,12 +2,16 @@ package services
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"slices"
+	"strings"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
 	"github.com/superdumb33/auth-service-test/internal/token"
 	"golang.org/x/crypto/bcrypt"
 )
@@ -17,63 +21,143 @@ var (
 	ErrInternal     = entities.ErrInternal
 	ErrRevoked      = entities.ErrRevoked
 	ErrUnauthorized = entities.ErrUnauthorized
+	ErrReused       = entities.ErrReused
+	ErrInvalidScope = entities.ErrInvalidScope
 
 	//funcs
 	GenerateAccessToken = token.GenerateAccessToken
+	GenerateIDToken = token.GenerateIDToken
 	GenerateRefreshToken = token.GenerateRefreshToken
 	GenerateBCryptHash = token.GenerateBCryptHash
 	VerifyRefreshToken = token.VerifyRefreshToken
-	ParseJWTToken = token.ParseJWTToken
+	ParseAccessToken = token.ParseAccessToken
+	RefreshTokenLookupHash = token.RefreshTokenLookupHash
 )
 
+// sessions are listed by pages of at most that size
+const MaxSessionPageSize = 100
+
 type Tokens struct {
 	AccessToken  string
 	RefreshToken string
+	//lifetime of access token
+	ExpiresIn time.Duration
+	Scope     string
+	//ID of the session (refresh_tokens row) tokens belong to; jti of access token
+	SessionID uuid.UUID
+	//OpenID Connect ID token; issued only for sessions granted openid scope
+	IDToken string
+}
+
+// parameters of a new session
+type sessionRequest struct {
+	clientID  string
+	userID    uuid.UUID
+	scope     string
+	userIP    string
+	userAgent string
+	//time the user authenticated at; zero for a fresh authentication
+	authTime time.Time
+	//OpenID Connect nonce to put into ID token
+	nonce string
 }
 
 type AuthRepo interface {
+	//runs fn in a transaction; repo methods called with ctx passed to fn take part in it
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
 	Create(ctx context.Context, rt *entities.RefreshToken) error
 	GetTokenByID(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error)
+	GetTokenByLookupHash(ctx context.Context, lookupHash string) (*entities.RefreshToken, error)
+	//locks session until the end of transaction
+	GetTokenByIDForUpdate(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error)
 	Revoke(ctx context.Context, id uuid.UUID) error
 	RevokeAllByUserID(ctx context.Context, userID uuid.UUID) error
+	//revokes every session of the user but keepID
+	RevokeOthersByUserID(ctx context.Context, userID, keepID uuid.UUID) error
+	//revokes sessions so that one more keeps the user within limit, or returns ErrSessionLimit if policy is reject;
+	//must be called within InTx along with Create, concurrent calls for the same user wait for each other
+	EnforceSessionLimit(ctx context.Context, userID uuid.UUID, limit int, policy entities.SessionLimitPolicy) error
+	//updates LastUsedAt of session
+	Touch(ctx context.Context, id uuid.UUID) error
+	//returns page of sessions that are neither revoked nor expired and their total number
+	ListActiveByUserID(ctx context.Context, userID uuid.UUID, params entities.SessionListParams) ([]*entities.RefreshToken, int, error)
+	MarkReplaced(ctx context.Context, id, replacedBy uuid.UUID) error
+	RevokeFamily(ctx context.Context, familyID uuid.UUID) error
 }
 
-type HTTPClient interface {
-	NotifyIPChange(ctx context.Context, userID uuid.UUID, oldIP, newIP string)
+// limits sessions are subject to besides refresh token TTL
+type SessionLimits struct {
+	//maximum number of active sessions per user; 0 means unlimited
+	MaxPerUser int
+	//what happens to a new session when MaxPerUser is reached
+	Policy entities.SessionLimitPolicy
+	//session can't be refreshed after that long since the login, however often it's refreshed; 0 means unlimited
+	MaxLifetime time.Duration
+	//session can't be refreshed after that long without being used; 0 means unlimited
+	IdleTimeout time.Duration
 }
 
 type AuthService struct {
 	accesTTL   time.Duration
 	refreshTTL time.Duration
+	limits     SessionLimits
 	repo       AuthRepo
-	client     HTTPClient
+	events     EventPublisher
 }
 
-func NewAuthService(repo AuthRepo, accessTTL, refreshTTL time.Duration, client HTTPClient) *AuthService {
-	return &AuthService{repo: repo, accesTTL: accessTTL, refreshTTL: refreshTTL, client: client}
+func NewAuthService(repo AuthRepo, accessTTL, refreshTTL time.Duration, limits SessionLimits, events EventPublisher) *AuthService {
+	return &AuthService{repo: repo, accesTTL: accessTTL, refreshTTL: refreshTTL, limits: limits, events: events}
 }
 
-func (as *AuthService) GenerateTokens(ctx context.Context, userID uuid.UUID, userIP, userAgent string) (Tokens, error) {
+func (as *AuthService) GenerateTokens(ctx context.Context, clientID string, userID uuid.UUID, scope, userIP, userAgent string) (tokens Tokens, err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.GenerateTokens")
+	defer func() { endSpan(span, err) }()
+
+	return as.generateTokens(ctx, sessionRequest{clientID: clientID, userID: userID, scope: scope, userIP: userIP, userAgent: userAgent})
+}
+
+func (as *AuthService) generateTokens(ctx context.Context, req sessionRequest) (Tokens, error) {
 	const op = "service:GenerateTokens"
 	refreshToken, err := GenerateRefreshToken()
 	if err != nil {
 		return Tokens{}, fmt.Errorf("%s:%w", op, err)
 	}
 
-	refreshTokenHash, err := GenerateBCryptHash(refreshToken)
+	refreshTokenHash, err := hashRefreshToken(ctx, refreshToken)
 	if err != nil {
 		return Tokens{}, fmt.Errorf("%s:%w", op, err)
 	}
 
 	rt := &entities.RefreshToken{
-		UserID:    userID,
+		UserID:    req.userID,
 		Hash:      string(refreshTokenHash),
 		IssuedAt:  time.Now(),
-		ExpiresAt: time.Now().Add(as.refreshTTL),
-		UserAgent: userAgent,
-		IPAddress: userIP,
+		UserAgent: req.userAgent,
+		IPAddress:  req.userIP,
+		ClientID:   req.clientID,
+		LookupHash: RefreshTokenLookupHash(refreshToken),
+		Scope:      req.scope,
+		AuthTime:   req.authTime,
+	}
+	if rt.AuthTime.IsZero() {
+		rt.AuthTime = rt.IssuedAt
 	}
-	if err := as.repo.Create(ctx, rt); err != nil {
+	rt.ExpiresAt = as.sessionExpiry(rt.AuthTime)
+	//rotation replaces a session with another one, so the limit is checked only here
+	err = as.repo.InTx(ctx, func(ctx context.Context) error {
+		if as.limits.MaxPerUser > 0 {
+			if err := as.repo.EnforceSessionLimit(ctx, req.userID, as.limits.MaxPerUser, as.limits.Policy); err != nil {
+				return err
+			}
+		}
+		if err := as.repo.Create(ctx, rt); err != nil {
+			return err
+		}
+		return as.events.Publish(ctx, entities.SessionIssued{
+			UserID: rt.UserID, SessionID: rt.ID, ClientID: rt.ClientID, IP: rt.IPAddress, UserAgent: rt.UserAgent,
+		})
+	})
+	if err != nil {
 		return Tokens{}, err
 	}
 
@@ -81,104 +165,654 @@ func (as *AuthService) GenerateTokens(ctx context.Context, userID uuid.UUID, use
 	if err != nil {
 		return Tokens{}, fmt.Errorf("%s:%w", op, err)
 	}
+	idToken, err := as.generateIDToken(rt, req.nonce)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+	}
+	metrics.TokensIssued.WithLabelValues("login").Inc()
 
 	return Tokens{
 		AccessToken:  accesToken,
 		RefreshToken: refreshToken,
+		ExpiresIn:    as.accesTTL,
+		Scope:        req.scope,
+		SessionID:    rt.ID,
+		IDToken:      idToken,
 	}, nil
 
 }
 
-func (as *AuthService) Refresh(ctx context.Context, accessToken, refreshToken, userIP, userAgent string) (Tokens, error) {
+func (as *AuthService) Refresh(ctx context.Context, accessToken, refreshToken, userIP, userAgent string) (tokens Tokens, err error) {
 	const op = "service:Refresh"
-	jwtToken, err := ParseJWTToken(accessToken, true)
+	ctx, span := tracer.Start(ctx, "AuthService.Refresh")
+	defer func() { endSpan(span, err) }()
+
+	jwtToken, err := ParseAccessToken(accessToken, true)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+		metrics.Refreshes.WithLabelValues(metrics.RefreshInvalidToken).Inc()
+		return Tokens{}, fmt.Errorf("%s:%w: %v", op, ErrUnauthorized, err)
 	}
 	claims := jwtToken.Claims.(jwt.MapClaims)
-	jti := claims["jti"].(string)
+	jti, ok := claims["jti"].(string)
+	if !ok {
+		metrics.Refreshes.WithLabelValues(metrics.RefreshInvalidToken).Inc()
+		return Tokens{}, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
 	parsedJTI, err := uuid.Parse(jti)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+		metrics.Refreshes.WithLabelValues(metrics.RefreshInvalidToken).Inc()
+		return Tokens{}, fmt.Errorf("%s:%w", op, ErrUnauthorized)
 	}
 
-	session, err := as.repo.GetTokenByID(ctx, parsedJTI)
+	return as.rotate(ctx, op, parsedJTI, refreshToken, "", "", userIP, userAgent)
+}
+
+// rotates session identified by refresh token alone (OAuth refresh_token grant); the session must be issued to clientID,
+// non-empty scope narrows the scope of the session down
+func (as *AuthService) RefreshByToken(ctx context.Context, clientID, refreshToken, scope, userIP, userAgent string) (tokens Tokens, err error) {
+	const op = "service:RefreshByToken"
+	ctx, span := tracer.Start(ctx, "AuthService.RefreshByToken")
+	defer func() { endSpan(span, err) }()
+
+	session, err := as.repo.GetTokenByLookupHash(ctx, RefreshTokenLookupHash(refreshToken))
 	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			metrics.Refreshes.WithLabelValues(metrics.RefreshUnknownSession).Inc()
+			return Tokens{}, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+		}
+		metrics.Refreshes.WithLabelValues(metrics.RefreshError).Inc()
 		return Tokens{}, err
 	}
 
-	if session.Revoked {
-		return Tokens{}, ErrRevoked
-	}
+	return as.rotate(ctx, op, session.ID, refreshToken, clientID, scope, userIP, userAgent)
+}
 
-	if userAgent != session.UserAgent {
-		if err := as.repo.Revoke(ctx, session.ID); err != nil {
-			return Tokens{}, err
-		}
-		return Tokens{}, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+// revokes session and creates the one replacing it; clientID and scope are checked only if not empty
+func (as *AuthService) rotate(ctx context.Context, op string, sessionID uuid.UUID, refreshToken, clientID, scope, userIP, userAgent string) (Tokens, error) {
+	//success or reason of the failure
+	result := metrics.RefreshError
+	defer func() { metrics.Refreshes.WithLabelValues(result).Inc() }()
+
+	//new refresh token is prepared before the session row is locked, bcrypt is slow
+	newRefreshToken, err := GenerateRefreshToken()
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%s:%w", op, err)
 	}
-	//if refresh token is expired - error is returned and the session is marked as revoked
-	if time.Now().After(session.ExpiresAt) {
-		as.repo.Revoke(ctx, session.ID)
-		return Tokens{}, fmt.Errorf("%s:%w", op, entities.ErrExpired)
+
+	newHash, err := hashRefreshToken(ctx, newRefreshToken)
+	if err != nil {
+		return Tokens{}, fmt.Errorf("%s:%w", op, err)
 	}
 
-	if err := VerifyRefreshToken(refreshToken, session.Hash); err != nil {
-		if err == bcrypt.ErrMismatchedHashAndPassword {
+	var (
+		session        *entities.RefreshToken
+		rt             *entities.RefreshToken
+		newAccessToken string
+		newIDToken     string
+		//returned after commit, so revocations made on the way to it aren't rolled back
+		failure error
+	)
+	//session row is locked until commit, so of concurrent refreshes with the same token exactly one rotates it,
+	//and the new session either replaces the old one completely or not at all
+	err = as.repo.InTx(ctx, func(ctx context.Context) error {
+		session, err = as.repo.GetTokenByIDForUpdate(ctx, sessionID)
+		if err != nil {
+			if errors.Is(err, entities.ErrNotFound) {
+				result = metrics.RefreshUnknownSession
+			}
+			return err
+		}
+
+		if session.Revoked {
+			result = metrics.RefreshRevoked
+			failure = ErrRevoked
+			//refresh token of rotated session is presented again: either the legitimate client or an attacker
+			//already used it, so the whole chain of sessions is compromised; there's no grace period, however soon after
+			//the rotation it happens, as an attacker rotating first would be indistinguishable from a client racing itself
+			if session.ReplacedBy != nil && verifyRefreshToken(ctx, refreshToken, session.Hash) == nil {
+				if err := as.repo.RevokeFamily(ctx, session.FamilyID); err != nil {
+					return err
+				}
+				if err := as.events.Publish(ctx, entities.TokenReused{
+					UserID: session.UserID, SessionID: session.ID, FamilyID: session.FamilyID, IP: userIP,
+				}); err != nil {
+					return err
+				}
+				metrics.Revocations.WithLabelValues(metrics.RevocationTokenReuse).Inc()
+				result = metrics.RefreshReused
+				failure = fmt.Errorf("%s:%w", op, ErrReused)
+			}
+
+			return nil
+		}
+
+		if userAgent != session.UserAgent {
+			if err := as.revokeUserAgentMismatch(ctx, session, userIP, userAgent); err != nil {
+				return err
+			}
+			result = metrics.RefreshUAMismatch
+			failure = fmt.Errorf("%s:%w", op, ErrUnauthorized)
+			return nil
+		}
+		//if refresh token is expired - error is returned and the session is marked as revoked
+		if time.Now().After(session.ExpiresAt) {
+			if err := as.revokeExpired(ctx, session, userIP, entities.ExpiryRefreshTokenTTL); err != nil {
+				return err
+			}
+			result = metrics.RefreshExpired
+			failure = fmt.Errorf("%s:%w", op, entities.ErrExpired)
+			return nil
+		}
+		//rotation grants a fresh refresh TTL, so the session itself must not be too old or idle for too long
+		if reason := as.SessionExpiryReason(session); reason != "" {
+			if err := as.revokeExpired(ctx, session, userIP, reason); err != nil {
+				return err
+			}
+			result = metrics.RefreshSessionExpired
+			failure = fmt.Errorf("%s:%w", op, entities.ErrExpired)
+			return nil
+		}
+
+		if err := verifyRefreshToken(ctx, refreshToken, session.Hash); err != nil {
+			if err != bcrypt.ErrMismatchedHashAndPassword {
+				return fmt.Errorf("%s:%w", op, err)
+			}
 			if err := as.repo.Revoke(ctx, session.ID); err != nil {
-				return Tokens{}, err
+				return err
 			}
+			metrics.Revocations.WithLabelValues(metrics.RevocationInvalidToken).Inc()
+			result = metrics.RefreshInvalidToken
+			failure = fmt.Errorf("%s:%w", op, ErrUnauthorized)
+			return nil
 		}
 
-		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+		if clientID != "" && clientID != session.ClientID {
+			result = metrics.RefreshClientMismatch
+			failure = fmt.Errorf("%s:%w", op, ErrUnauthorized)
+			return nil
+		}
+		if scope == "" {
+			scope = session.Scope
+		} else if !scopeSubset(scope, session.Scope) {
+			result = metrics.RefreshInvalidScope
+			failure = fmt.Errorf("%s:%w", op, ErrInvalidScope)
+			return nil
+		}
+
+		rt = &entities.RefreshToken{
+			UserID:    session.UserID,
+			Hash:      string(newHash),
+			IssuedAt:  time.Now(),
+			ExpiresAt: as.sessionExpiry(session.AuthTime),
+			UserAgent: session.UserAgent,
+			IPAddress: userIP,
+			FamilyID:   session.FamilyID,
+			ClientID:   session.ClientID,
+			LookupHash: RefreshTokenLookupHash(newRefreshToken),
+			Scope:      scope,
+			AuthTime:   session.AuthTime,
+		}
+		if err := as.repo.Create(ctx, rt); err != nil {
+			return err
+		}
+
+		//revoking old session and linking it to the new one
+		if err := as.repo.MarkReplaced(ctx, session.ID, rt.ID); err != nil {
+			return err
+		}
+		if session.IPAddress != userIP {
+			if err := as.events.Publish(ctx, entities.SessionIPChanged{
+				UserID: session.UserID, SessionID: rt.ID, PreviousSessionID: session.ID, OldIP: session.IPAddress, NewIP: userIP,
+			}); err != nil {
+				return err
+			}
+		}
+
+		newAccessToken, err = GenerateAccessToken(rt.ID.String(), as.accesTTL)
+		if err != nil {
+			return fmt.Errorf("%s:%w", op, err)
+		}
+		//nonce belongs to the authorization request only, refreshed ID tokens go without it
+		newIDToken, err = as.generateIDToken(rt, "")
+		if err != nil {
+			return fmt.Errorf("%s:%w", op, err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return Tokens{}, err
 	}
 
-	if session.IPAddress != userIP {
-		go as.client.NotifyIPChange(ctx, session.UserID, session.IPAddress, userIP)
+	if failure != nil {
+		return Tokens{}, failure
 	}
+	result = metrics.RefreshSuccess
+	metrics.TokensIssued.WithLabelValues("refresh").Inc()
 
-	//revoking old session. it's better to use trx to do this
+	return Tokens{
+		AccessToken:  newAccessToken,
+		RefreshToken: newRefreshToken,
+		ExpiresIn:    as.accesTTL,
+		Scope:        scope,
+		SessionID:    rt.ID,
+		IDToken:      newIDToken,
+	}, nil
+}
+
+// returns ID token for session granted openid scope by a client, empty string otherwise
+func (as *AuthService) generateIDToken(session *entities.RefreshToken, nonce string) (string, error) {
+	if session.ClientID == "" || !slices.Contains(strings.Fields(session.Scope), entities.ScopeOpenID) {
+		return "", nil
+	}
+
+	idToken, err := GenerateIDToken(token.IDTokenClaims{
+		Subject:   session.UserID.String(),
+		Audience:  session.ClientID,
+		Nonce:     nonce,
+		AuthTime:  session.AuthTime,
+		SessionID: session.ID.String(),
+	}, as.accesTTL)
+	//session was granted openid while an asymmetric key was active, it can't be served with HMAC keys alone
+	if errors.Is(err, token.ErrNoAsymmetricKey) {
+		return "", ErrInvalidScope
+	}
+
+	return idToken, err
+}
+
+// returns moments session can't be refreshed after besides its refresh token expiry: end of its absolute lifetime,
+// counted from the login, and end of idle timeout, counted from the last use; zero if the limit is off
+func (as *AuthService) SessionDeadlines(session *entities.RefreshToken) (absolute, idle time.Time) {
+	if as.limits.MaxLifetime > 0 {
+		absolute = session.AuthTime.Add(as.limits.MaxLifetime)
+	}
+	if as.limits.IdleTimeout > 0 {
+		idle = session.LastUsedAt.Add(as.limits.IdleTimeout)
+	}
+
+	return absolute, idle
+}
+
+// returns ExpiryMaxLifetime or ExpiryIdleTimeout if session is past one of its deadlines, empty string otherwise
+func (as *AuthService) SessionExpiryReason(session *entities.RefreshToken) string {
+	absolute, idle := as.SessionDeadlines(session)
+	switch {
+	case !absolute.IsZero() && time.Now().After(absolute):
+		return entities.ExpiryMaxLifetime
+	case !idle.IsZero() && time.Now().After(idle):
+		return entities.ExpiryIdleTimeout
+	}
+
+	return ""
+}
+
+func (as *AuthService) revokeExpired(ctx context.Context, session *entities.RefreshToken, userIP, reason string) error {
 	if err := as.repo.Revoke(ctx, session.ID); err != nil {
-		return Tokens{}, err
+		return err
+	}
+	if err := as.events.Publish(ctx, entities.ExpiredRefresh{
+		UserID: session.UserID, SessionID: session.ID, IP: userIP, Reason: reason,
+	}); err != nil {
+		return err
 	}
+	metrics.Revocations.WithLabelValues(metrics.RevocationExpired).Inc()
 
-	newRefreshToken, err := GenerateRefreshToken()
+	return nil
+}
+
+// revokes session used with userAgent other than the one it's issued to
+func (as *AuthService) RevokeUserAgentMismatch(ctx context.Context, session *entities.RefreshToken, userIP, userAgent string) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.RevokeUserAgentMismatch")
+	defer func() { endSpan(span, err) }()
+
+	return as.repo.InTx(ctx, func(ctx context.Context) error {
+		return as.revokeUserAgentMismatch(ctx, session, userIP, userAgent)
+	})
+}
+
+func (as *AuthService) revokeUserAgentMismatch(ctx context.Context, session *entities.RefreshToken, userIP, userAgent string) error {
+	if err := as.repo.Revoke(ctx, session.ID); err != nil {
+		return err
+	}
+	if err := as.events.Publish(ctx, entities.UserAgentMismatch{
+		UserID: session.UserID, SessionID: session.ID, IP: userIP, ExpectedUserAgent: session.UserAgent, UserAgent: userAgent,
+	}); err != nil {
+		return err
+	}
+	metrics.Revocations.WithLabelValues(metrics.RevocationUAMismatch).Inc()
+
+	return nil
+}
+
+// returns refresh token expiry of session issued now; it never outlives absolute lifetime of the session
+func (as *AuthService) sessionExpiry(authTime time.Time) time.Time {
+	expiresAt := time.Now().Add(as.refreshTTL)
+	if as.limits.MaxLifetime > 0 && authTime.Add(as.limits.MaxLifetime).Before(expiresAt) {
+		return authTime.Add(as.limits.MaxLifetime)
+	}
+
+	return expiresAt
+}
+
+// checks that every space-delimited scope of requested is granted
+func scopeSubset(requested, granted string) bool {
+	grantedScopes := strings.Fields(granted)
+	for _, s := range strings.Fields(requested) {
+		if !slices.Contains(grantedScopes, s) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (as *AuthService) Logout(ctx context.Context, userID, jti uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.Logout")
+	defer func() { endSpan(span, err) }()
+
+	err = as.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := as.repo.Revoke(ctx, jti); err != nil {
+			return err
+		}
+		return as.events.Publish(ctx, entities.SessionLoggedOut{UserID: userID, SessionID: jti})
+	})
 	if err != nil {
-		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+		return err
 	}
+	metrics.Revocations.WithLabelValues(metrics.RevocationLogout).Inc()
+
+	return nil
+}
+
+// revokes session along with every session rotated from it or into it
+func (as *AuthService) RevokeFamilyOf(ctx context.Context, sessionID uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.RevokeFamilyOf")
+	defer func() { endSpan(span, err) }()
 
-	newHash, err := GenerateBCryptHash(newRefreshToken)
+	session, err := as.repo.GetTokenByID(ctx, sessionID)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+		return err
 	}
 
-	rt := &entities.RefreshToken{
-		UserID:    session.UserID,
-		Hash:      string(newHash),
-		IssuedAt:  time.Now(),
-		ExpiresAt: time.Now().Add(as.refreshTTL),
-		UserAgent: session.UserAgent,
-		IPAddress: userIP,
+	return as.repo.RevokeFamily(ctx, session.FamilyID)
+}
+
+// revokes every session of the user; sessionID is the one the revocation is requested from
+func (as *AuthService) RevokeAllByUserID(ctx context.Context, userID, sessionID uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.RevokeAllByUserID")
+	defer func() { endSpan(span, err) }()
+
+	err = as.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := as.repo.RevokeAllByUserID(ctx, userID); err != nil {
+			return err
+		}
+		return as.events.Publish(ctx, entities.SessionsRevoked{UserID: userID, SessionID: sessionID})
+	})
+	if err != nil {
+		return err
 	}
-	if err := as.repo.Create(ctx, rt); err != nil {
-		return Tokens{}, err
+	metrics.Revocations.WithLabelValues(metrics.RevocationRevokeAll).Inc()
+
+	return nil
+}
+
+// returns page of user's active sessions and their total number
+func (as *AuthService) ListSessions(ctx context.Context, userID uuid.UUID, params entities.SessionListParams) (sessions []*entities.RefreshToken, total int, err error) {
+	const op = "service:ListSessions"
+	ctx, span := tracer.Start(ctx, "AuthService.ListSessions")
+	defer func() { endSpan(span, err) }()
+
+	if params.Limit < 1 || params.Limit > MaxSessionPageSize || params.Offset < 0 ||
+		(params.SortBy != entities.SessionSortIssuedAt && params.SortBy != entities.SessionSortExpiresAt) {
+		return nil, 0, fmt.Errorf("%s:%w", op, entities.ErrBadRequest)
+	}
+	//sessions past their deadlines can't be refreshed anymore, so they aren't active even if not revoked yet
+	now := time.Now()
+	if as.limits.MaxLifetime > 0 {
+		params.AuthTimeAfter = now.Add(-as.limits.MaxLifetime)
+	}
+	if as.limits.IdleTimeout > 0 {
+		params.LastUsedAfter = now.Add(-as.limits.IdleTimeout)
 	}
 
-	newAccessToken, err := GenerateAccessToken(rt.ID.String(), as.accesTTL)
+	sessions, total, err = as.repo.ListActiveByUserID(ctx, userID, params)
 	if err != nil {
-		return Tokens{}, fmt.Errorf("%s:%w", op, err)
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
 	}
 
-	return Tokens{
-		AccessToken:  newAccessToken,
-		RefreshToken: newRefreshToken,
+	return sessions, total, nil
+}
+
+// revokes every session of the user except the current one
+func (as *AuthService) RevokeOtherSessions(ctx context.Context, userID, currentSessionID uuid.UUID) (err error) {
+	ctx, span := tracer.Start(ctx, "AuthService.RevokeOtherSessions")
+	defer func() { endSpan(span, err) }()
+
+	err = as.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := as.repo.RevokeOthersByUserID(ctx, userID, currentSessionID); err != nil {
+			return err
+		}
+		return as.events.Publish(ctx, entities.SessionsRevoked{UserID: userID, SessionID: currentSessionID, KeptCurrent: true})
+	})
+	if err != nil {
+		return err
+	}
+	metrics.Revocations.WithLabelValues(metrics.RevocationRevokeOthers).Inc()
+
+	return nil
+}
+
+// revokes user's session; sessions of other users are reported as not found, so their IDs can't be probed
+func (as *AuthService) RevokeSession(ctx context.Context, userID, sessionID uuid.UUID) (err error) {
+	const op = "service:RevokeSession"
+	ctx, span := tracer.Start(ctx, "AuthService.RevokeSession")
+	defer func() { endSpan(span, err) }()
+
+	revoked := false
+	err = as.repo.InTx(ctx, func(ctx context.Context) error {
+		//locked, so concurrent revocations publish the event once
+		session, err := as.repo.GetTokenByIDForUpdate(ctx, sessionID)
+		if err != nil {
+			return err
+		}
+		if session.UserID != userID {
+			return entities.ErrNotFound
+		}
+		if session.Revoked {
+			return nil
+		}
+		if err := as.repo.Revoke(ctx, session.ID); err != nil {
+			return err
+		}
+		revoked = true
+		return as.events.Publish(ctx, entities.SessionRevoked{UserID: session.UserID, SessionID: session.ID, ClientID: session.ClientID,
+			RevokedBy: entities.RevokedByUser})
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if revoked {
+		metrics.Revocations.WithLabelValues(metrics.RevocationRevokeSession).Inc()
+	}
+
+	return nil
+}
+
+// token state reported by introspection (RFC 7662); everything but Active is empty for inactive tokens
+type TokenInfo struct {
+	Active bool
+	//"access_token" or "refresh_token"
+	TokenType string
+	UserID    uuid.UUID
+	ExpiresAt time.Time
+	//zero for access tokens issued before iat claim was introduced
+	IssuedAt  time.Time
+	SessionID uuid.UUID
+	ClientID  string
+	Scope     string
+}
+
+const (
+	TokenTypeAccess  = "access_token"
+	TokenTypeRefresh = "refresh_token"
+)
+
+// reports whether token is live: access token must be valid and signed by us, and its session must be neither revoked nor expired.
+// tokenTypeHint only decides which kind of token is looked up first (RFC 7662 section 2.1)
+func (as *AuthService) Introspect(ctx context.Context, tokenString, tokenTypeHint string) (info TokenInfo, err error) {
+	const op = "service:Introspect"
+	ctx, span := tracer.Start(ctx, "AuthService.Introspect")
+	defer func() { endSpan(span, err) }()
+
+	lookups := []func(context.Context, string) (TokenInfo, error){as.introspectAccessToken, as.introspectRefreshToken}
+	if tokenTypeHint == TokenTypeRefresh {
+		slices.Reverse(lookups)
+	}
+	for _, lookup := range lookups {
+		info, err := lookup(ctx, tokenString)
+		if err != nil {
+			return TokenInfo{}, fmt.Errorf("%s:%w", op, err)
+		}
+		if info.Active {
+			return info, nil
+		}
+	}
+
+	return TokenInfo{}, nil
+}
+
+func (as *AuthService) introspectAccessToken(ctx context.Context, tokenString string) (TokenInfo, error) {
+	jwtToken, err := ParseAccessToken(tokenString, false)
+	if err != nil || !jwtToken.Valid {
+		return TokenInfo{}, nil
+	}
+	claims := jwtToken.Claims.(jwt.MapClaims)
+	jti, _ := claims["jti"].(string)
+	sessionID, err := uuid.Parse(jti)
+	if err != nil {
+		return TokenInfo{}, nil
+	}
+	session, err := as.liveSession(as.repo.GetTokenByID(ctx, sessionID))
+	if err != nil || session == nil {
+		return TokenInfo{}, err
+	}
+
+	info := TokenInfo{
+		Active:    true,
+		TokenType: TokenTypeAccess,
+		UserID:    session.UserID,
+		SessionID: session.ID,
+		ClientID:  session.ClientID,
+		Scope:     session.Scope,
+	}
+	if exp, ok := claims["exp"].(float64); ok {
+		info.ExpiresAt = time.Unix(int64(exp), 0)
+	}
+	if iat, ok := claims["iat"].(float64); ok {
+		info.IssuedAt = time.Unix(int64(iat), 0)
+	}
+
+	return info, nil
+}
+
+// refresh token is identified by its lookup hash alone; it's sha256 of 32 random bytes, so bcrypt check adds nothing but latency
+func (as *AuthService) introspectRefreshToken(ctx context.Context, tokenString string) (TokenInfo, error) {
+	session, err := as.liveSession(as.sessionByRefreshToken(ctx, tokenString))
+	if err != nil || session == nil {
+		return TokenInfo{}, err
+	}
+
+	return TokenInfo{
+		Active:    true,
+		TokenType: TokenTypeRefresh,
+		UserID:    session.UserID,
+		ExpiresAt: session.ExpiresAt,
+		IssuedAt:  session.IssuedAt,
+		SessionID: session.ID,
+		ClientID:  session.ClientID,
+		Scope:     session.Scope,
 	}, nil
 }
 
-func (as *AuthService) Logout(ctx context.Context, jti uuid.UUID) error {
-	return as.repo.Revoke(ctx, jti)
+// returns session if it's neither revoked nor expired nor past its deadlines, nil if it is or doesn't exist
+func (as *AuthService) liveSession(session *entities.RefreshToken, err error) (*entities.RefreshToken, error) {
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	if session.Revoked || time.Now().After(session.ExpiresAt) || as.SessionExpiryReason(session) != "" {
+		return nil, nil
+	}
+
+	return session, nil
+}
+
+// revokes session token belongs to; unknown tokens and tokens issued to other clients are ignored,
+// the caller can't tell them apart from revoked ones (RFC 7009 section 2.2)
+func (as *AuthService) RevokeToken(ctx context.Context, clientID, tokenString, tokenTypeHint string) (err error) {
+	const op = "service:RevokeToken"
+	ctx, span := tracer.Start(ctx, "AuthService.RevokeToken")
+	defer func() { endSpan(span, err) }()
+
+	lookups := []func(context.Context, string) (*entities.RefreshToken, error){as.sessionByAccessToken, as.sessionByRefreshToken}
+	if tokenTypeHint == TokenTypeRefresh {
+		slices.Reverse(lookups)
+	}
+	for _, lookup := range lookups {
+		session, err := lookup(ctx, tokenString)
+		if err != nil {
+			if errors.Is(err, entities.ErrNotFound) {
+				continue
+			}
+			return fmt.Errorf("%s:%w", op, err)
+		}
+		if session.ClientID != clientID {
+			return nil
+		}
+		revoked := false
+		err = as.repo.InTx(ctx, func(ctx context.Context) error {
+			//locked, so concurrent revocations publish the event once
+			locked, err := as.repo.GetTokenByIDForUpdate(ctx, session.ID)
+			if err != nil || locked.Revoked {
+				return err
+			}
+			if err := as.repo.Revoke(ctx, locked.ID); err != nil {
+				return err
+			}
+			revoked = true
+			return as.events.Publish(ctx, entities.SessionRevoked{UserID: locked.UserID, SessionID: locked.ID, ClientID: locked.ClientID,
+				RevokedBy: entities.RevokedByClient})
+		})
+		if err != nil {
+			return fmt.Errorf("%s:%w", op, err)
+		}
+		if revoked {
+			metrics.Revocations.WithLabelValues(metrics.RevocationOAuthRevoke).Inc()
+		}
+		return nil
+	}
+
+	return nil
+}
+
+// expired access token still identifies its session, so the client can end it after the token expired
+func (as *AuthService) sessionByAccessToken(ctx context.Context, tokenString string) (*entities.RefreshToken, error) {
+	jwtToken, err := ParseAccessToken(tokenString, true)
+	if err != nil {
+		return nil, entities.ErrNotFound
+	}
+	jti, _ := jwtToken.Claims.(jwt.MapClaims)["jti"].(string)
+	sessionID, err := uuid.Parse(jti)
+	if err != nil {
+		return nil, entities.ErrNotFound
+	}
+
+	return as.repo.GetTokenByID(ctx, sessionID)
 }
 
-func (as *AuthService) RevokeAllByUserID(ctx context.Context, userID uuid.UUID) error {
-	return as.repo.RevokeAllByUserID(ctx, userID)
+func (as *AuthService) sessionByRefreshToken(ctx context.Context, tokenString string) (*entities.RefreshToken, error) {
+	return as.repo.GetTokenByLookupHash(ctx, RefreshTokenLookupHash(tokenString))
 }
@@ -3,17 +3,32 @@ package services_test
 import (
 	"context"
 	"errors"
+	"slices"
 	"testing"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
 	"github.com/superdumb33/auth-service-test/internal/services"
+	"github.com/superdumb33/auth-service-test/internal/token"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace/noop"
 )
 
 type MockAuthRepo struct {
-	Tokens map[string]*entities.RefreshToken
+	Tokens          map[string]*entities.RefreshToken
+	RevokedSessions []uuid.UUID
+	RevokedFamilies []uuid.UUID
+}
+
+func (mr *MockAuthRepo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
 }
 
 func (mr *MockAuthRepo) Create(ctx context.Context, rt *entities.RefreshToken) error {
@@ -25,23 +40,92 @@ func (mr *MockAuthRepo) Create(ctx context.Context, rt *entities.RefreshToken) e
 func (mr *MockAuthRepo) GetTokenByID(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error) {
 	token, ok := mr.Tokens[id.String()]
 	if !ok {
-		return nil, errors.New("not found")
+		return nil, entities.ErrNotFound
 	}
 	return token, nil
 }
 
+func (mr *MockAuthRepo) GetTokenByLookupHash(ctx context.Context, lookupHash string) (*entities.RefreshToken, error) {
+	for _, token := range mr.Tokens {
+		if token.LookupHash == lookupHash {
+			return token, nil
+		}
+	}
+	return nil, entities.ErrNotFound
+}
+
+func (mr *MockAuthRepo) GetTokenByIDForUpdate(ctx context.Context, id uuid.UUID) (*entities.RefreshToken, error) {
+	return mr.GetTokenByID(ctx, id)
+}
+
 func (mr *MockAuthRepo) Revoke(ctx context.Context, id uuid.UUID) error {
+	mr.RevokedSessions = append(mr.RevokedSessions, id)
 	return nil
 }
 func (mr *MockAuthRepo) RevokeAllByUserID(ctx context.Context, userID uuid.UUID) error {
 	return nil
 }
+func (mr *MockAuthRepo) RevokeOthersByUserID(ctx context.Context, userID, keepID uuid.UUID) error {
+	return nil
+}
+func (mr *MockAuthRepo) EnforceSessionLimit(ctx context.Context, userID uuid.UUID, limit int, policy entities.SessionLimitPolicy) error {
+	var active []*entities.RefreshToken
+	for _, token := range mr.Tokens {
+		if token.UserID == userID && !token.Revoked && time.Now().Before(token.ExpiresAt) {
+			active = append(active, token)
+		}
+	}
+	if len(active) < limit {
+		return nil
+	}
+	if policy == entities.SessionLimitReject {
+		return entities.ErrSessionLimit
+	}
+	slices.SortFunc(active, func(a, b *entities.RefreshToken) int { return a.AuthTime.Compare(b.AuthTime) })
+	for _, token := range active[:len(active)-limit+1] {
+		token.Revoked = true
+	}
+	return nil
+}
+func (mr *MockAuthRepo) Touch(ctx context.Context, id uuid.UUID) error {
+	return nil
+}
+func (mr *MockAuthRepo) ListActiveByUserID(ctx context.Context, userID uuid.UUID, params entities.SessionListParams) ([]*entities.RefreshToken, int, error) {
+	var sessions []*entities.RefreshToken
+	for _, token := range mr.Tokens {
+		if token.UserID == userID && !token.Revoked && time.Now().Before(token.ExpiresAt) &&
+			(params.AuthTimeAfter.IsZero() || token.AuthTime.After(params.AuthTimeAfter)) &&
+			(params.LastUsedAfter.IsZero() || token.LastUsedAt.After(params.LastUsedAfter)) {
+			sessions = append(sessions, token)
+		}
+	}
+	total := len(sessions)
+	sessions = sessions[min(params.Offset, total):min(params.Offset+params.Limit, total)]
+	return sessions, total, nil
+}
+func (mr *MockAuthRepo) MarkReplaced(ctx context.Context, id, replacedBy uuid.UUID) error {
+	return nil
+}
+func (mr *MockAuthRepo) RevokeFamily(ctx context.Context, familyID uuid.UUID) error {
+	mr.RevokedFamilies = append(mr.RevokedFamilies, familyID)
+	return nil
+}
+
+type MockPublisher struct {
+	Events []entities.Event
+}
 
-type MockHTTPClient struct {
+func (mp *MockPublisher) Publish(ctx context.Context, event entities.Event) error {
+	mp.Events = append(mp.Events, event)
+	return nil
 }
 
-func (mc *MockHTTPClient) NotifyIPChange(ctx context.Context, userID uuid.UUID, oldIP, newIP string) {
-}	
+func (mp *MockPublisher) Last() entities.Event {
+	if len(mp.Events) == 0 {
+		return nil
+	}
+	return mp.Events[len(mp.Events)-1]
+}
 
 func TestAuthService_GenerateTokens(t *testing.T) {
 	// сохраним оригинальные функции
@@ -57,7 +141,8 @@ func TestAuthService_GenerateTokens(t *testing.T) {
 	testUserID := uuid.New()
 
 	mockRepo := &MockAuthRepo{Tokens: make(map[string]*entities.RefreshToken)}
-	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, &MockHTTPClient{})
+	events := &MockPublisher{}
+	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, events)
 
 	t.Run("Success", func(t *testing.T) {
 		services.GenerateRefreshToken = func() (string, error) {
@@ -72,13 +157,47 @@ func TestAuthService_GenerateTokens(t *testing.T) {
 			return "mock-access-token", nil
 		}
 
-		tokens, err := service.GenerateTokens(context.Background(), testUserID, "123.123.123.123", "agent1")
+		tokens, err := service.GenerateTokens(context.Background(), "test-client", testUserID, "", "123.123.123.123", "agent1")
 		if err != nil {
 			t.Fatalf("unexpected error: %v", err)
 		}
 		if tokens.AccessToken != "mock-access-token" || tokens.RefreshToken != "mock-refresh-token" {
 			t.Fatalf("unexpected token values: %+v", tokens)
 		}
+		expected := entities.SessionIssued{
+			UserID: testUserID, SessionID: tokens.SessionID, ClientID: "test-client", IP: "123.123.123.123", UserAgent: "agent1",
+		}
+		if events.Last() != expected {
+			t.Fatalf("expected session issued event to be published, got %+v", events.Events)
+		}
+	})
+
+	t.Run("Traced", func(t *testing.T) {
+		recorder := tracetest.NewSpanRecorder()
+		otel.SetTracerProvider(sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder)))
+		defer otel.SetTracerProvider(noop.NewTracerProvider())
+
+		services.GenerateAccessToken = func(jti string, ttl time.Duration) (string, error) {
+			return "", errors.New("access token fail")
+		}
+		if _, err := service.GenerateTokens(context.Background(), "test-client", testUserID, "", "123.123.123.123", "agent1"); err == nil {
+			t.Fatal("expected error")
+		}
+
+		spans := map[string]sdktrace.ReadOnlySpan{}
+		for _, span := range recorder.Ended() {
+			spans[span.Name()] = span
+		}
+		root, hash := spans["AuthService.GenerateTokens"], spans["bcrypt.GenerateFromPassword"]
+		if root == nil || hash == nil {
+			t.Fatalf("expected service and bcrypt spans, got %v", spans)
+		}
+		if hash.Parent().SpanID() != root.SpanContext().SpanID() {
+			t.Fatal("expected bcrypt span to be a child of the service span")
+		}
+		if root.Status().Code != codes.Error {
+			t.Fatalf("expected error status, got %v", root.Status())
+		}
 	})
 
 	t.Run("RefreshToken generation fails", func(t *testing.T) {
@@ -86,7 +205,7 @@ func TestAuthService_GenerateTokens(t *testing.T) {
 			return "", errors.New("generation failed")
 		}
 
-		_, err := service.GenerateTokens(context.Background(), testUserID, "123.123.123.123", "agent1")
+		_, err := service.GenerateTokens(context.Background(), "test-client", testUserID, "", "123.123.123.123", "agent1")
 		if err == nil || err.Error() != "service:GenerateTokens:generation failed" {
 			t.Fatalf("expected generation failed error, got: %v", err)
 		}
@@ -101,7 +220,7 @@ func TestAuthService_GenerateTokens(t *testing.T) {
 			return nil, errors.New("bcrypt fail")
 		}
 
-		_, err := service.GenerateTokens(context.Background(), testUserID, "123.123.123.123", "agent1")
+		_, err := service.GenerateTokens(context.Background(), "test-client", testUserID, "", "123.123.123.123", "agent1")
 		if err == nil || err.Error() != "service:GenerateTokens:bcrypt fail" {
 			t.Fatalf("expected bcrypt fail error, got: %v", err)
 		}
@@ -120,16 +239,16 @@ func TestAuthService_GenerateTokens(t *testing.T) {
 			return "", errors.New("access token fail")
 		}
 
-		_, err := service.GenerateTokens(context.Background(), testUserID, "123.123.123.123", "agent1")
+		_, err := service.GenerateTokens(context.Background(), "test-client", testUserID, "", "123.123.123.123", "agent1")
 		if err == nil || err.Error() != "service:GenerateTokens:access token fail" {
 			t.Fatalf("expected access token fail error, got: %v", err)
 		}
 	})
 }
 func TestAuthService_Refresh(t *testing.T) {
-	originalFunc := services.ParseJWTToken
+	originalFunc := services.ParseAccessToken
 	defer func() {
-		services.ParseJWTToken = originalFunc
+		services.ParseAccessToken = originalFunc
 	}()
 
 	testUserID := uuid.New()
@@ -151,13 +270,24 @@ func TestAuthService_Refresh(t *testing.T) {
 		},
 	}
 
-	services.ParseJWTToken = func(token string, _ bool) (*jwt.Token, error) {
-		return jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
+	services.ParseAccessToken = func(token string, allowExpired bool) (*jwt.Token, error) {
+		parsed, err := jwt.Parse(token, func(t *jwt.Token) (interface{}, error) {
 			return []byte("super-secret"), nil
 		})
+		if errors.Is(err, jwt.ErrTokenExpired) && allowExpired {
+			return parsed, nil
+		}
+		return parsed, err
 	}
 
-	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, &MockHTTPClient{})
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+
+	events := &MockPublisher{}
+	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, events)
 
 	t.Run("Success", func(t *testing.T) {
 		tokens, err := service.Refresh(context.Background(), testAccessToken, testRefreshToken, "1.1.1.1", "agent1")
@@ -168,13 +298,31 @@ func TestAuthService_Refresh(t *testing.T) {
 		if tokens.AccessToken == "" || tokens.RefreshToken == "" {
 			t.Fatal("expected non-empty tokens")
 		}
+		expected := entities.SessionIPChanged{
+			UserID: testUserID, SessionID: tokens.SessionID, PreviousSessionID: testJTI, OldIP: "123.123.123.123", NewIP: "1.1.1.1",
+		}
+		if len(events.Events) != 1 || events.Events[0] != expected {
+			t.Fatalf("expected IP change event to be published, got %+v", events.Events)
+		}
 	})
 
 	t.Run("Mismatched UserAgent", func(t *testing.T) {
+		refreshes := testutil.ToFloat64(metrics.Refreshes.WithLabelValues(metrics.RefreshUAMismatch))
+		revocations := testutil.ToFloat64(metrics.Revocations.WithLabelValues(metrics.RevocationUAMismatch))
+
 		_, err := service.Refresh(context.Background(), testAccessToken, testRefreshToken, "1.1.1.1", "agent2")
 		if !errors.Is(err, entities.ErrUnauthorized) {
 			t.Fatalf("expected ErrUnauthorized, got %v", err)
 		}
+		if got := testutil.ToFloat64(metrics.Refreshes.WithLabelValues(metrics.RefreshUAMismatch)) - refreshes; got != 1 {
+			t.Fatalf("expected refresh failure to be counted once, got %v", got)
+		}
+		if got := testutil.ToFloat64(metrics.Revocations.WithLabelValues(metrics.RevocationUAMismatch)) - revocations; got != 1 {
+			t.Fatalf("expected revocation to be counted once, got %v", got)
+		}
+		if event, ok := events.Last().(entities.UserAgentMismatch); !ok || event.ExpectedUserAgent != "agent1" || event.UserAgent != "agent2" {
+			t.Fatalf("expected User-Agent mismatch event to be published, got %+v", events.Last())
+		}
 	})
 
 	t.Run("Mismatched Refresh Token", func(t *testing.T) {
@@ -185,8 +333,532 @@ func TestAuthService_Refresh(t *testing.T) {
 	})
 
 	t.Run("Expired Refresh Token", func(t *testing.T) {
-		mockRepo.Tokens[testJTI.String()].ExpiresAt = time.Now().Add(time.Second-1)
+		mockRepo.Tokens[testJTI.String()].ExpiresAt = time.Now().Add(-time.Second)
+		_, err := service.Refresh(context.Background(), testAccessToken, testRefreshToken, "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrExpired) {
+			t.Fatalf("expected ErrExpired, got %v", err)
+		}
+		if event, ok := events.Last().(entities.ExpiredRefresh); !ok || event.Reason != entities.ExpiryRefreshTokenTTL {
+			t.Fatalf("expected expired refresh event to be published, got %+v", events.Last())
+		}
+	})
+	t.Run("Reused Right After Rotation", func(t *testing.T) {
+		//whoever rotated first, the token presented again a moment later is reuse all the same
+		replacement := &entities.RefreshToken{ID: uuid.New(), IssuedAt: time.Now()}
+		mockRepo.Tokens[replacement.ID.String()] = replacement
+		testFamilyID := uuid.New()
+		session := mockRepo.Tokens[testJTI.String()]
+		session.ExpiresAt = time.Now().Add(time.Hour)
+		session.Revoked = true
+		session.ReplacedBy = &replacement.ID
+		session.FamilyID = testFamilyID
+
+		_, err := service.Refresh(context.Background(), testAccessToken, testRefreshToken, "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrReused) {
+			t.Fatalf("expected ErrReused, got %v", err)
+		}
+		if len(mockRepo.RevokedFamilies) != 1 || mockRepo.RevokedFamilies[0] != testFamilyID {
+			t.Fatalf("expected session family to be revoked, got %v", mockRepo.RevokedFamilies)
+		}
+		if event, ok := events.Last().(entities.TokenReused); !ok || event.FamilyID != testFamilyID {
+			t.Fatalf("expected token reuse event to be published, got %+v", events.Last())
+		}
+		mockRepo.RevokedFamilies = nil
+	})
+
+	t.Run("Reused Refresh Token", func(t *testing.T) {
+		replacement := &entities.RefreshToken{ID: uuid.New(), IssuedAt: time.Now().Add(-time.Minute)}
+		mockRepo.Tokens[replacement.ID.String()] = replacement
+		replacedBy := replacement.ID
+		testFamilyID := uuid.New()
+		session := mockRepo.Tokens[testJTI.String()]
+		session.ExpiresAt = time.Now().Add(time.Hour)
+		session.Revoked = true
+		session.ReplacedBy = &replacedBy
+		session.FamilyID = testFamilyID
+
+		_, err := service.Refresh(context.Background(), testAccessToken, testRefreshToken, "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrReused) {
+			t.Fatalf("expected ErrReused, got %v", err)
+		}
+		if len(mockRepo.RevokedFamilies) != 1 || mockRepo.RevokedFamilies[0] != testFamilyID {
+			t.Fatalf("expected session family to be revoked, got %v", mockRepo.RevokedFamilies)
+		}
+		expected := entities.TokenReused{UserID: testUserID, SessionID: testJTI, FamilyID: testFamilyID, IP: "123.123.123.123"}
+		if events.Last() != expected {
+			t.Fatalf("expected token reuse event to be published, got %+v", events.Last())
+		}
+	})
+
+	t.Run("Revoked Session", func(t *testing.T) {
+		mockRepo.Tokens[testJTI.String()].ReplacedBy = nil
+
 		_, err := service.Refresh(context.Background(), testAccessToken, testRefreshToken, "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrRevoked) {
+			t.Fatalf("expected ErrRevoked, got %v", err)
+		}
+	})
+
+	t.Run("ID Token", func(t *testing.T) {
+		services.ParseAccessToken = originalFunc
+		token.SetSigningKey(newES256SigningKey(t))
+		defer token.SetSigningKey(signingKey)
+		idToken, err := token.GenerateIDToken(token.IDTokenClaims{
+			Subject: testUserID.String(), Audience: "spa", AuthTime: time.Now(), SessionID: testJTI.String(),
+		}, time.Minute)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		_, err = service.Refresh(context.Background(), idToken, testRefreshToken, "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+}
+
+func TestAuthService_RefreshByToken(t *testing.T) {
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+
+	testRefreshToken := "refresh-plaintext"
+	newSession := func() *entities.RefreshToken {
+		return &entities.RefreshToken{
+			ID:         uuid.New(),
+			UserID:     uuid.New(),
+			Hash:       "$2b$12$I3D4cWeWmuaOIiSE5WSvZejPMwwaXwIOxOxIwv9fXvvgpoR0Qnxti",
+			ExpiresAt:  time.Now().Add(time.Hour),
+			UserAgent:  "agent1",
+			IPAddress:  "123.123.123.123",
+			ClientID:   "mobile",
+			LookupHash: services.RefreshTokenLookupHash(testRefreshToken),
+			Scope:      "read write",
+		}
+	}
+	newService := func(session *entities.RefreshToken) *services.AuthService {
+		mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{session.ID.String(): session}}
+		return services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, &MockPublisher{})
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		service := newService(newSession())
+		tokens, err := service.RefreshByToken(context.Background(), "mobile", testRefreshToken, "", "123.123.123.123", "agent1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tokens.AccessToken == "" || tokens.RefreshToken == "" || tokens.Scope != "read write" {
+			t.Fatalf("unexpected tokens: %+v", tokens)
+		}
+	})
+
+	t.Run("Narrowed scope", func(t *testing.T) {
+		service := newService(newSession())
+		tokens, err := service.RefreshByToken(context.Background(), "mobile", testRefreshToken, "read", "123.123.123.123", "agent1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tokens.Scope != "read" {
+			t.Fatalf("expected narrowed scope, got %q", tokens.Scope)
+		}
+	})
+
+	t.Run("Broadened scope", func(t *testing.T) {
+		service := newService(newSession())
+		_, err := service.RefreshByToken(context.Background(), "mobile", testRefreshToken, "read admin", "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrInvalidScope) {
+			t.Fatalf("expected ErrInvalidScope, got %v", err)
+		}
+	})
+
+	t.Run("Issued to another client", func(t *testing.T) {
+		service := newService(newSession())
+		_, err := service.RefreshByToken(context.Background(), "web", testRefreshToken, "", "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Unknown refresh token", func(t *testing.T) {
+		service := newService(newSession())
+		_, err := service.RefreshByToken(context.Background(), "mobile", "unknown", "", "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+}
+
+func TestAuthService_Introspect(t *testing.T) {
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+
+	testRefreshToken := "refresh-plaintext"
+	session := &entities.RefreshToken{
+		ID:         uuid.New(),
+		UserID:     uuid.New(),
+		IssuedAt:   time.Now(),
+		ExpiresAt:  time.Now().Add(time.Hour),
+		ClientID:   "mobile",
+		LookupHash: services.RefreshTokenLookupHash(testRefreshToken),
+		Scope:      "read",
+	}
+	mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{session.ID.String(): session}}
+	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, &MockPublisher{})
+	accessToken, err := token.GenerateAccessToken(session.ID.String(), time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	t.Run("Access token", func(t *testing.T) {
+		info, err := service.Introspect(context.Background(), accessToken, "")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !info.Active || info.TokenType != services.TokenTypeAccess || info.UserID != session.UserID ||
+			info.ClientID != "mobile" || info.Scope != "read" {
+			t.Fatalf("unexpected token info: %+v", info)
+		}
+	})
+
+	t.Run("Refresh token", func(t *testing.T) {
+		info, err := service.Introspect(context.Background(), testRefreshToken, services.TokenTypeRefresh)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !info.Active || info.TokenType != services.TokenTypeRefresh || info.SessionID != session.ID {
+			t.Fatalf("unexpected token info: %+v", info)
+		}
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		info, err := service.Introspect(context.Background(), "unknown", "")
+		if err != nil || info.Active {
+			t.Fatalf("expected inactive token, got %+v, %v", info, err)
+		}
+	})
+
+	t.Run("Unknown session", func(t *testing.T) {
+		orphan, _ := token.GenerateAccessToken(uuid.NewString(), time.Minute)
+		info, err := service.Introspect(context.Background(), orphan, "")
+		if err != nil || info.Active {
+			t.Fatalf("expected inactive token, got %+v, %v", info, err)
+		}
+	})
+
+	t.Run("Revoked session", func(t *testing.T) {
+		session.Revoked = true
+		defer func() { session.Revoked = false }()
+		info, err := service.Introspect(context.Background(), accessToken, "")
+		if err != nil || info.Active {
+			t.Fatalf("expected inactive token, got %+v, %v", info, err)
+		}
+	})
+
+	t.Run("Idle session", func(t *testing.T) {
+		service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{IdleTimeout: time.Hour}, &MockPublisher{})
+		session.LastUsedAt = time.Now().Add(-2 * time.Hour)
+		defer func() { session.LastUsedAt = time.Time{} }()
+		for _, tokenString := range []string{accessToken, testRefreshToken} {
+			info, err := service.Introspect(context.Background(), tokenString, "")
+			if err != nil || info.Active {
+				t.Fatalf("expected inactive token, got %+v, %v", info, err)
+			}
+		}
+	})
+}
+
+func TestAuthService_RevokeToken(t *testing.T) {
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+
+	testRefreshToken := "refresh-plaintext"
+	events := &MockPublisher{}
+	newSession := func() (*services.AuthService, *MockAuthRepo, *entities.RefreshToken) {
+		session := &entities.RefreshToken{
+			ID:         uuid.New(),
+			UserID:     uuid.New(),
+			ExpiresAt:  time.Now().Add(time.Hour),
+			ClientID:   "mobile",
+			LookupHash: services.RefreshTokenLookupHash(testRefreshToken),
+		}
+		mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{session.ID.String(): session}}
+		return services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, events), mockRepo, session
+	}
+
+	t.Run("Refresh token", func(t *testing.T) {
+		service, mockRepo, session := newSession()
+		if err := service.RevokeToken(context.Background(), "mobile", testRefreshToken, services.TokenTypeRefresh); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 1 || mockRepo.RevokedSessions[0] != session.ID {
+			t.Fatalf("expected session to be revoked, got %v", mockRepo.RevokedSessions)
+		}
+		if events.Last() != (entities.SessionRevoked{UserID: session.UserID, SessionID: session.ID, ClientID: "mobile",
+			RevokedBy: entities.RevokedByClient}) {
+			t.Fatalf("expected revocation event to be published, got %+v", events.Events)
+		}
+	})
+
+	t.Run("Revoked already", func(t *testing.T) {
+		service, mockRepo, session := newSession()
+		session.Revoked = true
+		published := len(events.Events)
+		if err := service.RevokeToken(context.Background(), "mobile", testRefreshToken, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 0 || len(events.Events) != published {
+			t.Fatalf("expected nothing to happen to revoked session, got %v", mockRepo.RevokedSessions)
+		}
+	})
+
+	t.Run("Expired access token", func(t *testing.T) {
+		service, mockRepo, session := newSession()
+		accessToken, _ := token.GenerateAccessToken(session.ID.String(), -time.Minute)
+		if err := service.RevokeToken(context.Background(), "mobile", accessToken, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 1 || mockRepo.RevokedSessions[0] != session.ID {
+			t.Fatalf("expected session to be revoked, got %v", mockRepo.RevokedSessions)
+		}
+	})
+
+	t.Run("Issued to another client", func(t *testing.T) {
+		service, mockRepo, _ := newSession()
+		if err := service.RevokeToken(context.Background(), "web", testRefreshToken, ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 0 {
+			t.Fatalf("expected session to stay active, got %v", mockRepo.RevokedSessions)
+		}
+	})
+
+	t.Run("Unknown token", func(t *testing.T) {
+		service, _, _ := newSession()
+		if err := service.RevokeToken(context.Background(), "mobile", "unknown", ""); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+}
+
+func TestAuthService_RevokeSession(t *testing.T) {
+	testUserID := uuid.New()
+	session := &entities.RefreshToken{ID: uuid.New(), UserID: testUserID, ExpiresAt: time.Now().Add(time.Hour), ClientID: "web"}
+
+	t.Run("Own session", func(t *testing.T) {
+		mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{session.ID.String(): session}}
+		events := &MockPublisher{}
+		service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, events)
+		if err := service.RevokeSession(context.Background(), testUserID, session.ID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 1 || mockRepo.RevokedSessions[0] != session.ID {
+			t.Fatalf("expected session to be revoked, got %v", mockRepo.RevokedSessions)
+		}
+		if events.Last() != (entities.SessionRevoked{UserID: testUserID, SessionID: session.ID, ClientID: "web",
+			RevokedBy: entities.RevokedByUser}) {
+			t.Fatalf("expected revocation event to be published, got %+v", events.Events)
+		}
+	})
+
+	t.Run("Session of another user", func(t *testing.T) {
+		mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{session.ID.String(): session}}
+		service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, &MockPublisher{})
+		err := service.RevokeSession(context.Background(), uuid.New(), session.ID)
+		if !errors.Is(err, entities.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 0 {
+			t.Fatalf("expected session to stay active, got %v", mockRepo.RevokedSessions)
+		}
+	})
+}
+
+func TestAuthService_Logout(t *testing.T) {
+	testUserID, sessionID := uuid.New(), uuid.New()
+	mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{}}
+	events := &MockPublisher{}
+	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, events)
+
+	if err := service.Logout(context.Background(), testUserID, sessionID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mockRepo.RevokedSessions) != 1 || mockRepo.RevokedSessions[0] != sessionID {
+		t.Fatalf("expected session to be revoked, got %v", mockRepo.RevokedSessions)
+	}
+	if events.Last() != (entities.SessionLoggedOut{UserID: testUserID, SessionID: sessionID}) {
+		t.Fatalf("expected logout event to be published, got %+v", events.Events)
+	}
+}
+
+func TestAuthService_ListSessions(t *testing.T) {
+	testUserID := uuid.New()
+	mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{}}
+	for _, session := range []*entities.RefreshToken{
+		{ID: uuid.New(), UserID: testUserID, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: uuid.New(), UserID: testUserID, ExpiresAt: time.Now().Add(time.Hour)},
+		{ID: uuid.New(), UserID: testUserID, ExpiresAt: time.Now().Add(time.Hour), Revoked: true},
+		{ID: uuid.New(), UserID: uuid.New(), ExpiresAt: time.Now().Add(time.Hour)},
+	} {
+		mockRepo.Tokens[session.ID.String()] = session
+	}
+	service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, services.SessionLimits{}, &MockPublisher{})
+
+	t.Run("Success", func(t *testing.T) {
+		params := entities.SessionListParams{Limit: 1, SortBy: entities.SessionSortIssuedAt}
+		sessions, total, err := service.ListSessions(context.Background(), testUserID, params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(sessions) != 1 || total != 2 {
+			t.Fatalf("expected 1 of 2 sessions, got %d of %d", len(sessions), total)
+		}
+	})
+
+	t.Run("Past deadlines", func(t *testing.T) {
+		limits := services.SessionLimits{MaxLifetime: 24 * time.Hour, IdleTimeout: time.Hour}
+		service := services.NewAuthService(mockRepo, time.Minute*5, time.Hour, limits, &MockPublisher{})
+		live := &entities.RefreshToken{ID: uuid.New(), UserID: uuid.New(), ExpiresAt: time.Now().Add(time.Hour),
+			AuthTime: time.Now().Add(-time.Hour), LastUsedAt: time.Now()}
+		idle := &entities.RefreshToken{ID: uuid.New(), UserID: live.UserID, ExpiresAt: time.Now().Add(time.Hour),
+			AuthTime: time.Now().Add(-time.Hour), LastUsedAt: time.Now().Add(-2 * time.Hour)}
+		tooOld := &entities.RefreshToken{ID: uuid.New(), UserID: live.UserID, ExpiresAt: time.Now().Add(time.Hour),
+			AuthTime: time.Now().Add(-48 * time.Hour), LastUsedAt: time.Now()}
+		for _, session := range []*entities.RefreshToken{live, idle, tooOld} {
+			mockRepo.Tokens[session.ID.String()] = session
+		}
+
+		params := entities.SessionListParams{Limit: 20, SortBy: entities.SessionSortIssuedAt}
+		sessions, total, err := service.ListSessions(context.Background(), live.UserID, params)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if total != 1 || len(sessions) != 1 || sessions[0].ID != live.ID {
+			t.Fatalf("expected only the live session, got %d of %d", len(sessions), total)
+		}
+	})
+
+	t.Run("Unknown sort field", func(t *testing.T) {
+		params := entities.SessionListParams{Limit: 20, SortBy: "token_hash"}
+		_, _, err := service.ListSessions(context.Background(), testUserID, params)
+		if !errors.Is(err, entities.ErrBadRequest) {
+			t.Fatalf("expected ErrBadRequest, got %v", err)
+		}
+	})
+
+	t.Run("Page too large", func(t *testing.T) {
+		params := entities.SessionListParams{Limit: services.MaxSessionPageSize + 1, SortBy: entities.SessionSortIssuedAt}
+		_, _, err := service.ListSessions(context.Background(), testUserID, params)
+		if !errors.Is(err, entities.ErrBadRequest) {
+			t.Fatalf("expected ErrBadRequest, got %v", err)
+		}
+	})
+}
+
+func TestAuthService_SessionLimit(t *testing.T) {
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+	testUserID := uuid.New()
+
+	newService := func(policy entities.SessionLimitPolicy) (*services.AuthService, *entities.RefreshToken) {
+		oldest := &entities.RefreshToken{
+			ID:        uuid.New(),
+			UserID:    testUserID,
+			ExpiresAt: time.Now().Add(time.Hour),
+			AuthTime:  time.Now().Add(-time.Hour),
+		}
+		mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{oldest.ID.String(): oldest}}
+		limits := services.SessionLimits{MaxPerUser: 2, Policy: policy}
+		return services.NewAuthService(mockRepo, time.Minute*5, time.Hour, limits, &MockPublisher{}), oldest
+	}
+
+	t.Run("Reject", func(t *testing.T) {
+		service, _ := newService(entities.SessionLimitReject)
+		if _, err := service.GenerateTokens(context.Background(), "web", testUserID, "", "1.1.1.1", "agent1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := service.GenerateTokens(context.Background(), "web", testUserID, "", "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrSessionLimit) {
+			t.Fatalf("expected ErrSessionLimit, got %v", err)
+		}
+	})
+
+	t.Run("Evict oldest", func(t *testing.T) {
+		service, oldest := newService(entities.SessionLimitEvictOldest)
+		for range 2 {
+			if _, err := service.GenerateTokens(context.Background(), "web", testUserID, "", "1.1.1.1", "agent1"); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+		}
+		if !oldest.Revoked {
+			t.Fatal("expected the oldest session to be evicted")
+		}
+	})
+}
+
+func TestAuthService_SessionLifetime(t *testing.T) {
+	signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(signingKey)
+
+	testRefreshToken := "refresh-plaintext"
+	limits := services.SessionLimits{MaxLifetime: 2 * time.Hour, IdleTimeout: 30 * time.Minute}
+	newSession := func(authTime, lastUsedAt time.Time) (*services.AuthService, *MockAuthRepo) {
+		session := &entities.RefreshToken{
+			ID:         uuid.New(),
+			UserID:     uuid.New(),
+			Hash:       "$2b$12$I3D4cWeWmuaOIiSE5WSvZejPMwwaXwIOxOxIwv9fXvvgpoR0Qnxti",
+			ExpiresAt:  time.Now().Add(time.Hour),
+			UserAgent:  "agent1",
+			IPAddress:  "123.123.123.123",
+			ClientID:   "mobile",
+			LookupHash: services.RefreshTokenLookupHash(testRefreshToken),
+			AuthTime:   authTime,
+			LastUsedAt: lastUsedAt,
+		}
+		mockRepo := &MockAuthRepo{Tokens: map[string]*entities.RefreshToken{session.ID.String(): session}}
+		return services.NewAuthService(mockRepo, time.Minute*5, time.Hour, limits, &MockPublisher{}), mockRepo
+	}
+
+	t.Run("Refresh token expiry is capped by lifetime", func(t *testing.T) {
+		authTime := time.Now().Add(-90 * time.Minute)
+		service, mockRepo := newSession(authTime, time.Now())
+		tokens, err := service.RefreshByToken(context.Background(), "mobile", testRefreshToken, "", "123.123.123.123", "agent1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		rotated := mockRepo.Tokens[tokens.SessionID.String()]
+		if !rotated.AuthTime.Equal(authTime) || !rotated.ExpiresAt.Equal(authTime.Add(limits.MaxLifetime)) {
+			t.Fatalf("unexpected rotated session: %+v", rotated)
+		}
+	})
+
+	t.Run("Lifetime exceeded", func(t *testing.T) {
+		service, mockRepo := newSession(time.Now().Add(-3*time.Hour), time.Now())
+		_, err := service.RefreshByToken(context.Background(), "mobile", testRefreshToken, "", "123.123.123.123", "agent1")
+		if !errors.Is(err, entities.ErrExpired) {
+			t.Fatalf("expected ErrExpired, got %v", err)
+		}
+		if len(mockRepo.RevokedSessions) != 1 {
+			t.Fatalf("expected session to be revoked, got %v", mockRepo.RevokedSessions)
+		}
+	})
+
+	t.Run("Idle timeout exceeded", func(t *testing.T) {
+		service, _ := newSession(time.Now(), time.Now().Add(-time.Hour))
+		_, err := service.RefreshByToken(context.Background(), "mobile", testRefreshToken, "", "123.123.123.123", "agent1")
 		if !errors.Is(err, entities.ErrExpired) {
 			t.Fatalf("expected ErrExpired, got %v", err)
 		}
@@ -0,0 +1,157 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/metrics"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+var (
+	//funcs
+	GenerateAuthorizationCode = token.GenerateAuthorizationCode
+	AuthorizationCodeHash     = token.AuthorizationCodeHash
+	VerifyPKCE                = token.VerifyPKCE
+	ValidPKCEValue            = token.ValidPKCEValue
+)
+
+// authorization codes are exchanged right after redirect, RFC 6749 section 4.1.2 recommends at most 10 minutes
+const authorizationCodeTTL = time.Minute
+
+type AuthorizationCodeRepo interface {
+	//runs fn in a transaction; repo methods called with ctx passed to fn take part in it
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+	Create(ctx context.Context, code *entities.AuthorizationCode) error
+	//locks code until the end of transaction
+	GetByHashForUpdate(ctx context.Context, codeHash string) (*entities.AuthorizationCode, error)
+	MarkUsed(ctx context.Context, codeHash string, sessionID uuid.UUID) error
+}
+
+// authorization code grant with PKCE (RFC 6749 section 4.1, RFC 7636); codes are exchanged for the same sessions AuthService issues
+type AuthorizationService struct {
+	repo        AuthorizationCodeRepo
+	authService *AuthService
+}
+
+func NewAuthorizationService(repo AuthorizationCodeRepo, authService *AuthService) *AuthorizationService {
+	return &AuthorizationService{repo: repo, authService: authService}
+}
+
+// authorization request (RFC 6749 section 4.1.1) of authenticated user
+type AuthorizationRequest struct {
+	ClientID string
+	UserID   uuid.UUID
+	//time the user authenticated at
+	AuthTime time.Time
+	//redirect_uri as sent in the request, may be empty; the same must be presented on exchange
+	RedirectURI         string
+	Scope               string
+	CodeChallenge       string
+	CodeChallengeMethod string
+	//OpenID Connect nonce
+	Nonce string
+}
+
+// issues authorization code for user; client, redirect URI and scope must be checked by the caller
+func (as *AuthorizationService) IssueCode(ctx context.Context, req AuthorizationRequest) (string, error) {
+	const op = "service:IssueCode"
+	if req.CodeChallengeMethod != token.PKCEMethodS256 || !ValidPKCEValue(req.CodeChallenge) {
+		return "", fmt.Errorf("%s:%w", op, entities.ErrBadRequest)
+	}
+
+	code, err := GenerateAuthorizationCode()
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	authCode := &entities.AuthorizationCode{
+		CodeHash:            AuthorizationCodeHash(code),
+		ClientID:            req.ClientID,
+		UserID:              req.UserID,
+		RedirectURI:         req.RedirectURI,
+		Scope:               req.Scope,
+		CodeChallenge:       req.CodeChallenge,
+		CodeChallengeMethod: req.CodeChallengeMethod,
+		Nonce:               req.Nonce,
+		AuthTime:            req.AuthTime,
+		IssuedAt:            time.Now(),
+		ExpiresAt:           time.Now().Add(authorizationCodeTTL),
+	}
+	if err := as.repo.Create(ctx, authCode); err != nil {
+		return "", err
+	}
+
+	return code, nil
+}
+
+// exchanges code for tokens; code presented for the second time revokes the session issued for it
+func (as *AuthorizationService) ExchangeCode(ctx context.Context, clientID, code, redirectURI, codeVerifier, userIP, userAgent string) (Tokens, error) {
+	const op = "service:ExchangeCode"
+	var tokens Tokens
+	//business errors are returned after commit, so revocation made on the way isn't rolled back
+	var failure error
+	err := as.repo.InTx(ctx, func(ctx context.Context) error {
+		authCode, err := as.repo.GetByHashForUpdate(ctx, AuthorizationCodeHash(code))
+		if err != nil {
+			if errors.Is(err, entities.ErrNotFound) {
+				failure = fmt.Errorf("%s:%w", op, ErrUnauthorized)
+				return nil
+			}
+			return err
+		}
+
+		//code may have been intercepted, tokens issued for it can't be trusted (RFC 6749 section 4.1.2)
+		if authCode.UsedAt != nil {
+			if authCode.SessionID != nil {
+				err := as.authService.RevokeFamilyOf(ctx, *authCode.SessionID)
+				if err != nil && !errors.Is(err, entities.ErrNotFound) {
+					return err
+				}
+				if err == nil {
+					metrics.Revocations.WithLabelValues(metrics.RevocationCodeReuse).Inc()
+				}
+			}
+			failure = fmt.Errorf("%s:%w", op, ErrReused)
+			return nil
+		}
+		if time.Now().After(authCode.ExpiresAt) {
+			failure = fmt.Errorf("%s:%w", op, entities.ErrExpired)
+			return nil
+		}
+		if authCode.ClientID != clientID || authCode.RedirectURI != redirectURI {
+			failure = fmt.Errorf("%s:%w", op, ErrUnauthorized)
+			return nil
+		}
+		if !VerifyPKCE(codeVerifier, authCode.CodeChallenge) {
+			failure = fmt.Errorf("%s:%w", op, ErrUnauthorized)
+			return nil
+		}
+
+		tokens, err = as.authService.generateTokens(ctx, sessionRequest{
+			clientID:  clientID,
+			userID:    authCode.UserID,
+			scope:     authCode.Scope,
+			userIP:    userIP,
+			userAgent: userAgent,
+			authTime:  authCode.AuthTime,
+			nonce:     authCode.Nonce,
+		})
+		if err != nil {
+			return err
+		}
+
+		return as.repo.MarkUsed(ctx, authCode.CodeHash, tokens.SessionID)
+	})
+	if err != nil {
+		return Tokens{}, err
+	}
+	if failure != nil {
+		return Tokens{}, failure
+	}
+
+	return tokens, nil
+}
@@ -0,0 +1,227 @@
+package services_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+type MockAuthorizationCodeRepo struct {
+	Codes map[string]*entities.AuthorizationCode
+}
+
+func (mr *MockAuthorizationCodeRepo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (mr *MockAuthorizationCodeRepo) Create(ctx context.Context, code *entities.AuthorizationCode) error {
+	mr.Codes[code.CodeHash] = code
+	return nil
+}
+
+func (mr *MockAuthorizationCodeRepo) GetByHashForUpdate(ctx context.Context, codeHash string) (*entities.AuthorizationCode, error) {
+	code, ok := mr.Codes[codeHash]
+	if !ok {
+		return nil, entities.ErrNotFound
+	}
+	return code, nil
+}
+
+func (mr *MockAuthorizationCodeRepo) MarkUsed(ctx context.Context, codeHash string, sessionID uuid.UUID) error {
+	now := time.Now()
+	mr.Codes[codeHash].UsedAt = &now
+	mr.Codes[codeHash].SessionID = &sessionID
+	return nil
+}
+
+func TestAuthorizationService_ExchangeCode(t *testing.T) {
+	origAccessGenFunc := services.GenerateAccessToken
+	defer func() {
+		services.GenerateAccessToken = origAccessGenFunc
+	}()
+	services.GenerateAccessToken = func(jti string, ttl time.Duration) (string, error) {
+		return "mock-access-token", nil
+	}
+
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	sum := sha256.Sum256([]byte(verifier))
+	challenge := base64.RawURLEncoding.EncodeToString(sum[:])
+	redirectURI := "https://app.example.com/callback"
+	testUserID := uuid.New()
+
+	authRepo := &MockAuthRepo{Tokens: make(map[string]*entities.RefreshToken)}
+	codeRepo := &MockAuthorizationCodeRepo{Codes: make(map[string]*entities.AuthorizationCode)}
+	authService := services.NewAuthService(authRepo, time.Minute*5, time.Hour, services.SessionLimits{}, &MockPublisher{})
+	service := services.NewAuthorizationService(codeRepo, authService)
+
+	issueCode := func(t *testing.T) string {
+		code, err := service.IssueCode(context.Background(), services.AuthorizationRequest{
+			ClientID:            "spa",
+			UserID:              testUserID,
+			AuthTime:            time.Now(),
+			RedirectURI:         redirectURI,
+			Scope:               "profile",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return code
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		tokens, err := service.ExchangeCode(context.Background(), "spa", issueCode(t), redirectURI, verifier, "1.1.1.1", "agent1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if tokens.Scope != "profile" || authRepo.Tokens[tokens.SessionID.String()].UserID != testUserID {
+			t.Fatalf("unexpected tokens: %+v", tokens)
+		}
+	})
+
+	t.Run("Wrong verifier", func(t *testing.T) {
+		_, err := service.ExchangeCode(context.Background(), "spa", issueCode(t), redirectURI, verifier[1:]+"x", "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Wrong redirect URI", func(t *testing.T) {
+		_, err := service.ExchangeCode(context.Background(), "spa", issueCode(t), "https://evil.example.com", verifier, "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Another client", func(t *testing.T) {
+		_, err := service.ExchangeCode(context.Background(), "other", issueCode(t), redirectURI, verifier, "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Expired code", func(t *testing.T) {
+		code := issueCode(t)
+		codeRepo.Codes[services.AuthorizationCodeHash(code)].ExpiresAt = time.Now().Add(-time.Second)
+		_, err := service.ExchangeCode(context.Background(), "spa", code, redirectURI, verifier, "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrExpired) {
+			t.Fatalf("expected ErrExpired, got %v", err)
+		}
+	})
+
+	t.Run("Reused code", func(t *testing.T) {
+		code := issueCode(t)
+		tokens, err := service.ExchangeCode(context.Background(), "spa", code, redirectURI, verifier, "1.1.1.1", "agent1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = service.ExchangeCode(context.Background(), "spa", code, redirectURI, verifier, "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrReused) {
+			t.Fatalf("expected ErrReused, got %v", err)
+		}
+		familyID := authRepo.Tokens[tokens.SessionID.String()].FamilyID
+		if len(authRepo.RevokedFamilies) == 0 || authRepo.RevokedFamilies[len(authRepo.RevokedFamilies)-1] != familyID {
+			t.Fatalf("expected family %s to be revoked, got %v", familyID, authRepo.RevokedFamilies)
+		}
+	})
+
+	t.Run("OpenID scope", func(t *testing.T) {
+		token.SetSigningKey(newES256SigningKey(t))
+
+		code, err := service.IssueCode(context.Background(), services.AuthorizationRequest{
+			ClientID:            "spa",
+			UserID:              testUserID,
+			AuthTime:            time.Now(),
+			RedirectURI:         redirectURI,
+			Scope:               "openid",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+			Nonce:               "nonce",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		tokens, err := service.ExchangeCode(context.Background(), "spa", code, redirectURI, verifier, "1.1.1.1", "agent1")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		idToken, err := token.ParseJWTToken(tokens.IDToken, false)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		claims := idToken.Claims.(jwt.MapClaims)
+		if claims["sub"] != testUserID.String() || claims["aud"] != "spa" || claims["nonce"] != "nonce" ||
+			claims["sid"] != tokens.SessionID.String() {
+			t.Fatalf("unexpected ID token claims: %v", claims)
+		}
+	})
+
+	t.Run("OpenID scope with HMAC key only", func(t *testing.T) {
+		signingKey, err := token.LoadSigningKey("HS512", "", "super-secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		token.SetSigningKey(signingKey)
+
+		code, err := service.IssueCode(context.Background(), services.AuthorizationRequest{
+			ClientID:            "spa",
+			UserID:              testUserID,
+			AuthTime:            time.Now(),
+			RedirectURI:         redirectURI,
+			Scope:               "openid",
+			CodeChallenge:       challenge,
+			CodeChallengeMethod: "S256",
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err = service.ExchangeCode(context.Background(), "spa", code, redirectURI, verifier, "1.1.1.1", "agent1")
+		if !errors.Is(err, entities.ErrInvalidScope) {
+			t.Fatalf("expected ErrInvalidScope, got %v", err)
+		}
+	})
+
+	t.Run("Plain challenge method", func(t *testing.T) {
+		_, err := service.IssueCode(context.Background(), services.AuthorizationRequest{
+			ClientID:            "spa",
+			UserID:              testUserID,
+			RedirectURI:         redirectURI,
+			CodeChallenge:       verifier,
+			CodeChallengeMethod: "plain",
+		})
+		if !errors.Is(err, entities.ErrBadRequest) {
+			t.Fatalf("expected ErrBadRequest, got %v", err)
+		}
+	})
+}
+
+// ID tokens are signed with asymmetric keys only
+func newES256SigningKey(t *testing.T) *token.SigningKey {
+	t.Helper()
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, err := x509.MarshalPKCS8PrivateKey(privateKey)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	signingKey, err := token.ParseSigningKeyPEM(jwt.SigningMethodES256, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return signingKey
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/token"
+	"golang.org/x/crypto/bcrypt"
+)
+
+var (
+	//funcs
+	ParseClientAssertion = token.ParseClientAssertion
+	AssertionClientID    = token.AssertionClientID
+)
+
+type ClientRepo interface {
+	GetClientByID(ctx context.Context, id string) (*entities.Client, error)
+	//returns ErrDuplicate if the client already used assertion with this jti
+	SaveAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error
+}
+
+type ClientService struct {
+	repo ClientRepo
+}
+
+func NewClientService(repo ClientRepo) *ClientService {
+	return &ClientService{repo: repo}
+}
+
+// authenticates client with its ID and secret (HTTP Basic auth)
+func (cs *ClientService) AuthenticateSecret(ctx context.Context, clientID, secret string) (*entities.Client, error) {
+	const op = "service:AuthenticateSecret"
+	client, err := cs.getClient(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if client.SecretHash == "" {
+		return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(client.SecretHash), []byte(secret)); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
+
+	return client, nil
+}
+
+// authenticates client with JWT assertion signed by its private key (RFC 7523);
+// every assertion can be used only once
+func (cs *ClientService) AuthenticateAssertion(ctx context.Context, assertion string, audiences []string) (*entities.Client, error) {
+	const op = "service:AuthenticateAssertion"
+	clientID, err := AssertionClientID(assertion)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
+	client, err := cs.getClient(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if client.PublicKey == "" {
+		return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
+
+	verified, err := ParseClientAssertion(assertion, client.ID, client.PublicKey, audiences)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
+	if err := cs.repo.SaveAssertion(ctx, client.ID, verified.JTI, verified.ExpiresAt); err != nil {
+		if errors.Is(err, entities.ErrDuplicate) {
+			return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
+
+// identifies public client by its ID alone; clients having credentials must authenticate with them
+func (cs *ClientService) IdentifyPublicClient(ctx context.Context, clientID string) (*entities.Client, error) {
+	const op = "service:IdentifyPublicClient"
+	client, err := cs.getClient(ctx, clientID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if !client.IsPublic() {
+		return nil, fmt.Errorf("%s:%w", op, ErrUnauthorized)
+	}
+
+	return client, nil
+}
+
+// returns registered client without authenticating it, e.g. to validate authorization request
+func (cs *ClientService) GetClient(ctx context.Context, clientID string) (*entities.Client, error) {
+	return cs.repo.GetClientByID(ctx, clientID)
+}
+
+// unknown client is reported as ErrUnauthorized
+func (cs *ClientService) getClient(ctx context.Context, clientID string) (*entities.Client, error) {
+	client, err := cs.repo.GetClientByID(ctx, clientID)
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return nil, ErrUnauthorized
+		}
+		return nil, err
+	}
+
+	return client, nil
+}
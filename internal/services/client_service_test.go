@@ -0,0 +1,144 @@
+package services_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type MockClientRepo struct {
+	Clients    map[string]*entities.Client
+	Assertions map[string]bool
+}
+
+func (mr *MockClientRepo) GetClientByID(ctx context.Context, id string) (*entities.Client, error) {
+	client, ok := mr.Clients[id]
+	if !ok {
+		return nil, entities.ErrNotFound
+	}
+	return client, nil
+}
+
+func (mr *MockClientRepo) SaveAssertion(ctx context.Context, clientID, jti string, expiresAt time.Time) error {
+	if mr.Assertions[clientID+jti] {
+		return entities.ErrDuplicate
+	}
+	mr.Assertions[clientID+jti] = true
+	return nil
+}
+
+func TestClientService_AuthenticateSecret(t *testing.T) {
+	secretHash, _ := bcrypt.GenerateFromPassword([]byte("client-secret"), bcrypt.MinCost)
+	mockRepo := &MockClientRepo{Clients: map[string]*entities.Client{
+		"backend":    {ID: "backend", SecretHash: string(secretHash)},
+		"key-client": {ID: "key-client"},
+	}}
+	service := services.NewClientService(mockRepo)
+
+	t.Run("Success", func(t *testing.T) {
+		client, err := service.AuthenticateSecret(context.Background(), "backend", "client-secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if client.ID != "backend" {
+			t.Fatalf("unexpected client: %+v", client)
+		}
+	})
+
+	t.Run("Wrong secret", func(t *testing.T) {
+		_, err := service.AuthenticateSecret(context.Background(), "backend", "wrong")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Unknown client", func(t *testing.T) {
+		_, err := service.AuthenticateSecret(context.Background(), "unknown", "client-secret")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Client without secret", func(t *testing.T) {
+		_, err := service.AuthenticateSecret(context.Background(), "key-client", "")
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+}
+
+func TestClientService_AuthenticateAssertion(t *testing.T) {
+	privateKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	der, _ := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	publicKeyPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})
+
+	mockRepo := &MockClientRepo{
+		Clients: map[string]*entities.Client{
+			"key-client": {ID: "key-client", PublicKey: string(publicKeyPEM)},
+		},
+		Assertions: map[string]bool{},
+	}
+	service := services.NewClientService(mockRepo)
+	audiences := []string{"https://auth.example.com/api/v1/auth/issue"}
+
+	newAssertion := func(aud string, ttl time.Duration) string {
+		assertion, err := jwt.NewWithClaims(jwt.SigningMethodES256, jwt.MapClaims{
+			"iss": "key-client",
+			"sub": "key-client",
+			"aud": aud,
+			"jti": uuid.NewString(),
+			"exp": time.Now().Add(ttl).Unix(),
+		}).SignedString(privateKey)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return assertion
+	}
+
+	t.Run("Success", func(t *testing.T) {
+		client, err := service.AuthenticateAssertion(context.Background(), newAssertion(audiences[0], time.Minute), audiences)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if client.ID != "key-client" {
+			t.Fatalf("unexpected client: %+v", client)
+		}
+	})
+
+	t.Run("Replayed assertion", func(t *testing.T) {
+		assertion := newAssertion(audiences[0], time.Minute)
+		if _, err := service.AuthenticateAssertion(context.Background(), assertion, audiences); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		_, err := service.AuthenticateAssertion(context.Background(), assertion, audiences)
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Wrong audience", func(t *testing.T) {
+		_, err := service.AuthenticateAssertion(context.Background(), newAssertion("https://other.example.com", time.Minute), audiences)
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+
+	t.Run("Long lived assertion", func(t *testing.T) {
+		_, err := service.AuthenticateAssertion(context.Background(), newAssertion(audiences[0], time.Hour), audiences)
+		if !errors.Is(err, entities.ErrUnauthorized) {
+			t.Fatalf("expected ErrUnauthorized, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// reports security events to webhooks; called within the transaction of the change the event reports,
+// so the event is published if and only if the change is committed
+type EventPublisher interface {
+	Publish(ctx context.Context, event entities.Event) error
+}
+
+// webhooks are written within the transaction of ctx, once per subscription accepting the event,
+// and delivered by OutboxDispatcher after commit
+type Outbox interface {
+	Enqueue(ctx context.Context, msg *entities.OutboxMessage) error
+}
+
+// EventPublisher writing events to the outbox
+type OutboxPublisher struct {
+	outbox Outbox
+}
+
+func NewOutboxPublisher(outbox Outbox) *OutboxPublisher {
+	return &OutboxPublisher{outbox: outbox}
+}
+
+// wraps event into EventEnvelope and writes it to the outbox along with trace context of ctx
+func (op *OutboxPublisher) Publish(ctx context.Context, event entities.Event) error {
+	const opName = "OutboxPublisher:Publish"
+	envelope := entities.EventEnvelope{
+		SchemaVersion: entities.EventSchemaVersion,
+		ID:            uuid.New(),
+		Type:          event.Type(),
+		OccurredAt:    time.Now().UTC(),
+		UserID:        event.Subject(),
+		Data:          event,
+	}
+	payload, err := json.Marshal(envelope)
+	if err != nil {
+		return fmt.Errorf("%s:%w", opName, err)
+	}
+	traceContext := propagation.MapCarrier{}
+	otel.GetTextMapPropagator().Inject(ctx, traceContext)
+
+	msg := &entities.OutboxMessage{EventID: envelope.ID, Event: envelope.Type, UserID: envelope.UserID, Payload: payload,
+		TraceContext: traceContext}
+	if err := op.outbox.Enqueue(ctx, msg); err != nil {
+		return fmt.Errorf("%s:%w", opName, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package services_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+type MockOutbox struct {
+	Messages []*entities.OutboxMessage
+}
+
+func (mo *MockOutbox) Enqueue(ctx context.Context, msg *entities.OutboxMessage) error {
+	mo.Messages = append(mo.Messages, msg)
+	return nil
+}
+
+func TestOutboxPublisher_Publish(t *testing.T) {
+	outbox := &MockOutbox{}
+	publisher := services.NewOutboxPublisher(outbox)
+	userID, sessionID := uuid.New(), uuid.New()
+
+	err := publisher.Publish(context.Background(), entities.SessionLoggedOut{UserID: userID, SessionID: sessionID})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(outbox.Messages) != 1 {
+		t.Fatalf("expected a message to be enqueued, got %d", len(outbox.Messages))
+	}
+	msg := outbox.Messages[0]
+	if msg.Event != entities.EventSessionLoggedOut || msg.UserID != userID {
+		t.Fatalf("expected %s message about %s, got %s about %s", entities.EventSessionLoggedOut, userID, msg.Event, msg.UserID)
+	}
+
+	var envelope map[string]interface{}
+	if err := json.Unmarshal(msg.Payload, &envelope); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	data, _ := envelope["data"].(map[string]interface{})
+	//ID of the event is sent in the headers as well
+	if envelope["schema_version"] != entities.EventSchemaVersion || envelope["id"] != msg.EventID.String() ||
+		envelope["type"] != string(entities.EventSessionLoggedOut) || envelope["user_id"] != userID.String() ||
+		envelope["occurred_at"] == nil || data["session_id"] != sessionID.String() || len(data) != 1 {
+		t.Fatalf("unexpected payload: %s", msg.Payload)
+	}
+}
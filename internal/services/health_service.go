@@ -0,0 +1,113 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+var CurrentSigningKey = token.CurrentSigningKey
+
+type HealthRepo interface {
+	Ping(ctx context.Context) error
+	MigrationVersion(ctx context.Context) (version uint, dirty bool, err error)
+}
+
+const (
+	ComponentDatabase   = "database"
+	ComponentMigrations = "migrations"
+	ComponentSigningKey = "signing_key"
+)
+
+// result of a single readiness check
+type ComponentHealth struct {
+	Healthy bool
+	//safe to show to anyone, unlike Cause
+	Error   string
+	Cause   error
+	Details map[string]string
+}
+
+type HealthReport struct {
+	Ready      bool
+	Components map[string]ComponentHealth
+}
+
+type HealthService struct {
+	repo HealthRepo
+	//schema version the service is built for
+	migrationVersion uint
+	//tokens of that TTL must be signable for the service to be ready
+	accessTTL time.Duration
+	//time given to every check
+	timeout time.Duration
+}
+
+func NewHealthService(repo HealthRepo, migrationVersion uint, accessTTL, timeout time.Duration) *HealthService {
+	return &HealthService{repo: repo, migrationVersion: migrationVersion, accessTTL: accessTTL, timeout: timeout}
+}
+
+// checks everything needed to serve requests; the service is ready if every component is healthy
+func (hs *HealthService) Readiness(ctx context.Context) HealthReport {
+	report := HealthReport{Ready: true, Components: map[string]ComponentHealth{
+		ComponentDatabase:   hs.checkDatabase(ctx),
+		ComponentMigrations: hs.checkMigrations(ctx),
+		ComponentSigningKey: hs.checkSigningKey(),
+	}}
+	for _, component := range report.Components {
+		report.Ready = report.Ready && component.Healthy
+	}
+
+	return report
+}
+
+func (hs *HealthService) checkDatabase(ctx context.Context) ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, hs.timeout)
+	defer cancel()
+	if err := hs.repo.Ping(ctx); err != nil {
+		return ComponentHealth{Error: "database is unreachable", Cause: err}
+	}
+
+	return ComponentHealth{Healthy: true}
+}
+
+// newer schema is fine, it's applied by a newer version of the service being rolled out
+func (hs *HealthService) checkMigrations(ctx context.Context) ComponentHealth {
+	ctx, cancel := context.WithTimeout(ctx, hs.timeout)
+	defer cancel()
+	details := map[string]string{"expected": strconv.FormatUint(uint64(hs.migrationVersion), 10)}
+	version, dirty, err := hs.repo.MigrationVersion(ctx)
+	if err != nil {
+		if errors.Is(err, entities.ErrNotFound) {
+			return ComponentHealth{Error: "no migrations applied", Details: details}
+		}
+		return ComponentHealth{Error: "schema version is unknown", Cause: err, Details: details}
+	}
+	details["current"] = strconv.FormatUint(uint64(version), 10)
+	switch {
+	case dirty:
+		return ComponentHealth{Error: fmt.Sprintf("migration %d failed halfway", version), Details: details}
+	case version < hs.migrationVersion:
+		return ComponentHealth{Error: "schema is outdated", Details: details}
+	}
+
+	return ComponentHealth{Healthy: true, Details: details}
+}
+
+func (hs *HealthService) checkSigningKey() ComponentHealth {
+	key, err := CurrentSigningKey(hs.accessTTL)
+	if err != nil {
+		return ComponentHealth{Error: "no usable signing key", Cause: err}
+	}
+	details := map[string]string{"kid": key.ID, "alg": key.Method.Alg()}
+	if !key.NotAfter.IsZero() {
+		details["not_after"] = key.NotAfter.UTC().Format(time.RFC3339)
+	}
+
+	return ComponentHealth{Healthy: true, Details: details}
+}
@@ -0,0 +1,75 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+type MockHealthRepo struct {
+	PingErr    error
+	Version    uint
+	Dirty      bool
+	VersionErr error
+}
+
+func (mr *MockHealthRepo) Ping(ctx context.Context) error {
+	return mr.PingErr
+}
+
+func (mr *MockHealthRepo) MigrationVersion(ctx context.Context) (uint, bool, error) {
+	return mr.Version, mr.Dirty, mr.VersionErr
+}
+
+func TestHealthService_Readiness(t *testing.T) {
+	origCurrentSigningKey := services.CurrentSigningKey
+	defer func() { services.CurrentSigningKey = origCurrentSigningKey }()
+	services.CurrentSigningKey = func(ttl time.Duration) (*token.SigningKey, error) {
+		return &token.SigningKey{ID: "2025-q4", Method: jwt.SigningMethodES256}, nil
+	}
+
+	tests := []struct {
+		name      string
+		repo      *MockHealthRepo
+		ready     bool
+		unhealthy string
+	}{
+		{"Ready", &MockHealthRepo{Version: 8}, true, ""},
+		{"Newer schema", &MockHealthRepo{Version: 9}, true, ""},
+		{"Database unreachable", &MockHealthRepo{Version: 8, PingErr: errors.New("connection refused")}, false, services.ComponentDatabase},
+		{"Outdated schema", &MockHealthRepo{Version: 7}, false, services.ComponentMigrations},
+		{"Dirty schema", &MockHealthRepo{Version: 8, Dirty: true}, false, services.ComponentMigrations},
+		{"No migrations", &MockHealthRepo{VersionErr: entities.ErrNotFound}, false, services.ComponentMigrations},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			service := services.NewHealthService(tt.repo, 8, time.Minute, time.Second)
+			report := service.Readiness(context.Background())
+			if report.Ready != tt.ready {
+				t.Fatalf("expected ready=%v, got %+v", tt.ready, report)
+			}
+			for name, component := range report.Components {
+				if component.Healthy == (name == tt.unhealthy) {
+					t.Fatalf("unexpected health of %s: %+v", name, component)
+				}
+			}
+		})
+	}
+
+	t.Run("No signing key", func(t *testing.T) {
+		services.CurrentSigningKey = func(ttl time.Duration) (*token.SigningKey, error) {
+			return nil, token.ErrNoActiveKey
+		}
+		service := services.NewHealthService(&MockHealthRepo{Version: 8}, 8, time.Minute, time.Second)
+		report := service.Readiness(context.Background())
+		if report.Ready || report.Components[services.ComponentSigningKey].Healthy {
+			t.Fatalf("expected signing key to be unhealthy, got %+v", report)
+		}
+	})
+}
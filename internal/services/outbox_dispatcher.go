@@ -0,0 +1,163 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+)
+
+// batch is claimed for deliveryTimeout plus that long, which covers marking the messages;
+// message still claimed by a dispatcher must never be claimed by another one, or it's delivered twice
+const outboxLeaseMargin = 15 * time.Second
+
+type OutboxRepo interface {
+	ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*entities.OutboxMessage, error)
+	MarkDelivered(ctx context.Context, id uuid.UUID) error
+	MarkFailed(ctx context.Context, id uuid.UUID, lastError string, nextAttemptAt *time.Time) error
+}
+
+type WebhookSender interface {
+	//returns the attempt made along with its error; no attempt if the request couldn't be made
+	Deliver(ctx context.Context, msg *entities.OutboxMessage) (*entities.WebhookDelivery, error)
+}
+
+// keeps delivery attempts for inspection and replay
+type DeliveryLog interface {
+	Record(ctx context.Context, delivery *entities.WebhookDelivery) error
+}
+
+// how failed deliveries are retried
+type RetryPolicy struct {
+	//message is dead after that many failed attempts
+	MaxAttempts int
+	//delay after the first failure, doubled after every next one
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+}
+
+// returns delay before the next attempt of a message that failed attempts times
+func (rp RetryPolicy) Delay(attempts int) time.Duration {
+	delay := rp.BaseDelay
+	for i := 1; i < attempts && delay < rp.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	return min(delay, rp.MaxDelay)
+}
+
+// delivers messages of the outbox; a message is delivered at least once, so receivers should deduplicate
+type OutboxDispatcher struct {
+	repo       OutboxRepo
+	sender     WebhookSender
+	deliveries DeliveryLog
+	retry      RetryPolicy
+	//messages of a batch are sent at once
+	batchSize int
+	//single delivery is cancelled after that long
+	deliveryTimeout time.Duration
+}
+
+func NewOutboxDispatcher(repo OutboxRepo, sender WebhookSender, deliveries DeliveryLog, retry RetryPolicy, batchSize int,
+	deliveryTimeout time.Duration) *OutboxDispatcher {
+	return &OutboxDispatcher{repo: repo, sender: sender, deliveries: deliveries, retry: retry, batchSize: batchSize,
+		deliveryTimeout: deliveryTimeout}
+}
+
+// long enough to deliver a batch even if its messages time out, as they're sent at once; kept short,
+// so messages of a dispatcher that stops are picked up by another one soon
+func (od *OutboxDispatcher) lease() time.Duration {
+	return od.deliveryTimeout + outboxLeaseMargin
+}
+
+// number of messages handled by a single dispatch
+type DispatchResult struct {
+	Delivered int
+	Retried   int
+	Dead      int
+}
+
+// delivers due messages batch by batch until none is left; stops between batches once ctx is done,
+// claimed messages left undelivered are picked up again when their lease ends
+func (od *OutboxDispatcher) Dispatch(ctx context.Context) (DispatchResult, error) {
+	const op = "OutboxDispatcher:Dispatch"
+	var result DispatchResult
+	for {
+		if err := ctx.Err(); err != nil {
+			return result, fmt.Errorf("%s:%w", op, err)
+		}
+		//taken before claiming, so the lease is never thought to last longer than it does
+		leaseEnd := time.Now().Add(od.lease())
+		messages, err := od.repo.ClaimDue(ctx, od.batchSize, od.lease())
+		if err != nil {
+			return result, fmt.Errorf("%s:%w", op, err)
+		}
+		//e.g. database got slow; the batch is left for whoever claims it after the lease
+		if time.Now().Add(od.deliveryTimeout).After(leaseEnd) {
+			return result, nil
+		}
+		if err := od.deliver(ctx, messages, &result); err != nil {
+			return result, fmt.Errorf("%s:%w", op, err)
+		}
+		if len(messages) < od.batchSize {
+			return result, nil
+		}
+	}
+}
+
+// sends messages at once, then marks them and records the attempts
+func (od *OutboxDispatcher) deliver(ctx context.Context, messages []*entities.OutboxMessage, result *DispatchResult) error {
+	deliveries := make([]*entities.WebhookDelivery, len(messages))
+	deliveryErrs := make([]error, len(messages))
+	var wg sync.WaitGroup
+	for i, msg := range messages {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			msgCtx, cancel := context.WithTimeout(otel.GetTextMapPropagator().Extract(ctx, propagation.MapCarrier(msg.TraceContext)),
+				od.deliveryTimeout)
+			defer cancel()
+			deliveries[i], deliveryErrs[i] = od.sender.Deliver(msgCtx, msg)
+		}()
+	}
+	wg.Wait()
+
+	for i, msg := range messages {
+		if err := od.mark(ctx, msg, deliveryErrs[i], result); err != nil {
+			return err
+		}
+	}
+	//attempts are recorded once the messages are marked, so failing to record one doesn't get them delivered again
+	for _, delivery := range deliveries {
+		if delivery == nil {
+			continue
+		}
+		if err := od.deliveries.Record(ctx, delivery); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (od *OutboxDispatcher) mark(ctx context.Context, msg *entities.OutboxMessage, deliveryErr error, result *DispatchResult) error {
+	if deliveryErr == nil {
+		result.Delivered++
+		return od.repo.MarkDelivered(ctx, msg.ID)
+	}
+
+	attempts := msg.Attempts + 1
+	if attempts >= od.retry.MaxAttempts {
+		result.Dead++
+		return od.repo.MarkFailed(ctx, msg.ID, deliveryErr.Error(), nil)
+	}
+	result.Retried++
+	next := time.Now().Add(od.retry.Delay(attempts))
+
+	return od.repo.MarkFailed(ctx, msg.ID, deliveryErr.Error(), &next)
+}
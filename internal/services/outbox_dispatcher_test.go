@@ -0,0 +1,243 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+type MockOutboxRepo struct {
+	Due       []*entities.OutboxMessage
+	Delivered []uuid.UUID
+	//next attempt of failed messages, nil for dead ones
+	Failed map[uuid.UUID]*time.Time
+}
+
+func (mr *MockOutboxRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*entities.OutboxMessage, error) {
+	n := min(limit, len(mr.Due))
+	claimed := mr.Due[:n]
+	mr.Due = mr.Due[n:]
+	return claimed, nil
+}
+
+func (mr *MockOutboxRepo) MarkDelivered(ctx context.Context, id uuid.UUID) error {
+	mr.Delivered = append(mr.Delivered, id)
+	return nil
+}
+
+func (mr *MockOutboxRepo) MarkFailed(ctx context.Context, id uuid.UUID, lastError string, nextAttemptAt *time.Time) error {
+	mr.Failed[id] = nextAttemptAt
+	return nil
+}
+
+// fails deliveries of the listed events
+type MockWebhookSender struct {
+	Failing map[entities.EventType]bool
+}
+
+func (ms *MockWebhookSender) Deliver(ctx context.Context, msg *entities.OutboxMessage) (*entities.WebhookDelivery, error) {
+	delivery := &entities.WebhookDelivery{OutboxID: msg.ID, Attempt: msg.Attempts + 1, Status: entities.DeliverySucceeded}
+	if ms.Failing[msg.Event] {
+		delivery.Status, delivery.Error = entities.DeliveryFailed, "connection refused"
+		return delivery, errors.New("connection refused")
+	}
+	return delivery, nil
+}
+
+type MockDeliveryLog struct {
+	Deliveries []*entities.WebhookDelivery
+}
+
+func (ml *MockDeliveryLog) Record(ctx context.Context, delivery *entities.WebhookDelivery) error {
+	ml.Deliveries = append(ml.Deliveries, delivery)
+	return nil
+}
+
+func TestRetryPolicy_Delay(t *testing.T) {
+	policy := services.RetryPolicy{MaxAttempts: 10, BaseDelay: 10 * time.Second, MaxDelay: time.Minute}
+	expected := []time.Duration{10 * time.Second, 20 * time.Second, 40 * time.Second, time.Minute, time.Minute}
+	for i, want := range expected {
+		if got := policy.Delay(i + 1); got != want {
+			t.Fatalf("attempt %d: expected %v, got %v", i+1, want, got)
+		}
+	}
+}
+
+func TestOutboxDispatcher_Dispatch(t *testing.T) {
+	policy := services.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	delivered := &entities.OutboxMessage{ID: uuid.New(), Event: entities.EventSessionIPChanged}
+	retried := &entities.OutboxMessage{ID: uuid.New(), Event: entities.EventTokenReused, Attempts: 1}
+	dead := &entities.OutboxMessage{ID: uuid.New(), Event: entities.EventTokenReused, Attempts: 2}
+	mockRepo := &MockOutboxRepo{Due: []*entities.OutboxMessage{delivered, retried, dead}, Failed: map[uuid.UUID]*time.Time{}}
+	sender := &MockWebhookSender{Failing: map[entities.EventType]bool{entities.EventTokenReused: true}}
+	deliveries := &MockDeliveryLog{}
+	dispatcher := services.NewOutboxDispatcher(mockRepo, sender, deliveries, policy, 2, time.Second)
+
+	result, err := dispatcher.Dispatch(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result != (services.DispatchResult{Delivered: 1, Retried: 1, Dead: 1}) {
+		t.Fatalf("unexpected result: %+v", result)
+	}
+	if len(mockRepo.Delivered) != 1 || mockRepo.Delivered[0] != delivered.ID {
+		t.Fatalf("expected message to be delivered, got %v", mockRepo.Delivered)
+	}
+	//second failure is retried after twice the base delay
+	next := mockRepo.Failed[retried.ID]
+	if next == nil || time.Until(*next) < time.Second || time.Until(*next) > 2*time.Second {
+		t.Fatalf("expected retry in 2s, got %v", next)
+	}
+	if next, ok := mockRepo.Failed[dead.ID]; !ok || next != nil {
+		t.Fatalf("expected message to be dead after %d attempts, got %v", policy.MaxAttempts, next)
+	}
+	if len(deliveries.Deliveries) != 3 || deliveries.Deliveries[2].OutboxID != dead.ID || deliveries.Deliveries[2].Attempt != 3 ||
+		deliveries.Deliveries[2].Status != entities.DeliveryFailed {
+		t.Fatalf("expected every attempt to be recorded, got %+v", deliveries.Deliveries)
+	}
+}
+
+// hands out only messages that aren't leased, the way the database does
+type LeasingOutboxRepo struct {
+	mu          sync.Mutex
+	Messages    []*entities.OutboxMessage
+	leasedUntil map[uuid.UUID]time.Time
+	handled     map[uuid.UUID]bool
+	Claims      map[uuid.UUID]int
+	Leases      []time.Duration
+}
+
+func (lr *LeasingOutboxRepo) ClaimDue(ctx context.Context, limit int, lease time.Duration) ([]*entities.OutboxMessage, error) {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.Leases = append(lr.Leases, lease)
+	var claimed []*entities.OutboxMessage
+	for _, msg := range lr.Messages {
+		if len(claimed) == limit {
+			break
+		}
+		if lr.handled[msg.ID] || time.Now().Before(lr.leasedUntil[msg.ID]) {
+			continue
+		}
+		lr.leasedUntil[msg.ID] = time.Now().Add(lease)
+		lr.Claims[msg.ID]++
+		claimed = append(claimed, msg)
+	}
+	return claimed, nil
+}
+
+func (lr *LeasingOutboxRepo) MarkDelivered(ctx context.Context, id uuid.UUID) error {
+	lr.mu.Lock()
+	defer lr.mu.Unlock()
+	lr.handled[id] = true
+	return nil
+}
+
+func (lr *LeasingOutboxRepo) MarkFailed(ctx context.Context, id uuid.UUID, lastError string, nextAttemptAt *time.Time) error {
+	return lr.MarkDelivered(ctx, id)
+}
+
+// takes Delay to respond, or doesn't respond at all if Delay is 0
+type SlowWebhookSender struct {
+	Delay time.Duration
+	mu    sync.Mutex
+	Sent  map[uuid.UUID]int
+}
+
+func (ss *SlowWebhookSender) Deliver(ctx context.Context, msg *entities.OutboxMessage) (*entities.WebhookDelivery, error) {
+	ss.mu.Lock()
+	ss.Sent[msg.ID]++
+	ss.mu.Unlock()
+	if ss.Delay == 0 {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}
+	select {
+	case <-time.After(ss.Delay):
+		return nil, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func TestOutboxDispatcher_Lease(t *testing.T) {
+	policy := services.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Second, MaxDelay: time.Minute}
+	newRepo := func(n int) *LeasingOutboxRepo {
+		repo := &LeasingOutboxRepo{leasedUntil: map[uuid.UUID]time.Time{}, handled: map[uuid.UUID]bool{}, Claims: map[uuid.UUID]int{}}
+		for i := 0; i < n; i++ {
+			repo.Messages = append(repo.Messages, &entities.OutboxMessage{ID: uuid.New(), Event: entities.EventSessionIPChanged})
+		}
+		return repo
+	}
+
+	t.Run("Slow receiver", func(t *testing.T) {
+		const batchSize, deliveryTimeout = 5, 30 * time.Second
+		repo := newRepo(10)
+		sender := &SlowWebhookSender{Delay: 10 * time.Millisecond, Sent: map[uuid.UUID]int{}}
+
+		//two dispatchers, e.g. of two replicas, race for the same messages
+		var wg sync.WaitGroup
+		for i := 0; i < 2; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				dispatcher := services.NewOutboxDispatcher(repo, sender, &MockDeliveryLog{}, policy, batchSize, deliveryTimeout)
+				if _, err := dispatcher.Dispatch(context.Background()); err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		for _, msg := range repo.Messages {
+			if repo.Claims[msg.ID] != 1 || sender.Sent[msg.ID] != 1 {
+				t.Fatalf("expected message to be claimed and sent once, got %d claims and %d sends", repo.Claims[msg.ID], sender.Sent[msg.ID])
+			}
+		}
+		//a batch is sent at once, so its lease only covers a single delivery timing out
+		for _, lease := range repo.Leases {
+			if lease < deliveryTimeout || lease > deliveryTimeout+time.Minute {
+				t.Fatalf("expected lease to cover a single delivery timing out, got %v", lease)
+			}
+		}
+	})
+
+	t.Run("Batch sent at once", func(t *testing.T) {
+		const delay = 100 * time.Millisecond
+		repo := newRepo(5)
+		sender := &SlowWebhookSender{Delay: delay, Sent: map[uuid.UUID]int{}}
+		dispatcher := services.NewOutboxDispatcher(repo, sender, &MockDeliveryLog{}, policy, 5, time.Second)
+
+		start := time.Now()
+		result, err := dispatcher.Dispatch(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Delivered != 5 {
+			t.Fatalf("expected every message to be delivered, got %+v", result)
+		}
+		if elapsed := time.Since(start); elapsed >= 3*delay {
+			t.Fatalf("expected messages of the batch to be sent at once, took %v", elapsed)
+		}
+	})
+
+	t.Run("Receiver not responding", func(t *testing.T) {
+		repo := newRepo(2)
+		sender := &SlowWebhookSender{Sent: map[uuid.UUID]int{}}
+		dispatcher := services.NewOutboxDispatcher(repo, sender, &MockDeliveryLog{}, policy, 5, 20*time.Millisecond)
+
+		result, err := dispatcher.Dispatch(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Retried != 2 {
+			t.Fatalf("expected deliveries to time out and be retried, got %+v", result)
+		}
+	})
+}
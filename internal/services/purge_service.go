@@ -0,0 +1,80 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+type PurgeRepo interface {
+	DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int64, error)
+	DeleteExpiredAuthorizationCodes(ctx context.Context, before time.Time, limit int) (int64, error)
+	DeleteExpiredAssertions(ctx context.Context, before time.Time, limit int) (int64, error)
+	DeleteDeliveredWebhooks(ctx context.Context, before time.Time, limit int) (int64, error)
+	DeleteWebhookDeliveries(ctx context.Context, before time.Time, limit int) (int64, error)
+}
+
+// number of rows removed by a single purge
+type PurgeResult struct {
+	Sessions           int64
+	AuthorizationCodes int64
+	ClientAssertions   int64
+	Webhooks           int64
+	WebhookDeliveries  int64
+}
+
+func (pr PurgeResult) Total() int64 {
+	return pr.Sessions + pr.AuthorizationCodes + pr.ClientAssertions + pr.Webhooks + pr.WebhookDeliveries
+}
+
+type PurgeService struct {
+	repo PurgeRepo
+	//rows are kept for that long after they expire
+	retention time.Duration
+	//webhook delivery attempts are kept for that long
+	deliveryRetention time.Duration
+	batchSize         int
+}
+
+func NewPurgeService(repo PurgeRepo, retention, deliveryRetention time.Duration, batchSize int) *PurgeService {
+	return &PurgeService{repo: repo, retention: retention, deliveryRetention: deliveryRetention, batchSize: batchSize}
+}
+
+// deletes sessions, authorization codes and client assertions expired more than retention ago,
+// webhooks delivered more than retention ago and delivery attempts older than deliveryRetention, batch by batch;
+// stops between batches once ctx is done and returns what was removed so far along with ctx error
+func (ps *PurgeService) Purge(ctx context.Context) (PurgeResult, error) {
+	const op = "PurgeService:Purge"
+	var result PurgeResult
+	now := time.Now()
+	before := now.Add(-ps.retention)
+
+	steps := []struct {
+		removed *int64
+		before  time.Time
+		del     func(ctx context.Context, before time.Time, limit int) (int64, error)
+	}{
+		{&result.AuthorizationCodes, before, ps.repo.DeleteExpiredAuthorizationCodes},
+		{&result.Sessions, before, ps.repo.DeleteExpiredSessions},
+		{&result.ClientAssertions, before, ps.repo.DeleteExpiredAssertions},
+		{&result.Webhooks, before, ps.repo.DeleteDeliveredWebhooks},
+		{&result.WebhookDeliveries, now.Add(-ps.deliveryRetention), ps.repo.DeleteWebhookDeliveries},
+	}
+	for _, step := range steps {
+		for {
+			if err := ctx.Err(); err != nil {
+				return result, fmt.Errorf("%s:%w", op, err)
+			}
+			removed, err := step.del(ctx, step.before, ps.batchSize)
+			if err != nil {
+				return result, fmt.Errorf("%s:%w", op, err)
+			}
+			*step.removed += removed
+			if removed < int64(ps.batchSize) {
+				break
+			}
+		}
+	}
+
+	return result, nil
+}
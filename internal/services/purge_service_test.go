@@ -0,0 +1,95 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+// every table holds the given number of expired rows
+type MockPurgeRepo struct {
+	Sessions, Codes, Assertions, Webhooks, Deliveries int64
+	Calls                                             int
+	Before                                            time.Time
+	DeliveriesBefore                                  time.Time
+	//cancelled after the given number of calls if set
+	Cancel      context.CancelFunc
+	CancelAfter int
+}
+
+func (mr *MockPurgeRepo) delete(rows *int64, before time.Time, limit int) (int64, error) {
+	mr.Calls++
+	mr.Before = before
+	if mr.Cancel != nil && mr.Calls == mr.CancelAfter {
+		mr.Cancel()
+	}
+	removed := min(*rows, int64(limit))
+	*rows -= removed
+	return removed, nil
+}
+
+func (mr *MockPurgeRepo) DeleteExpiredSessions(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return mr.delete(&mr.Sessions, before, limit)
+}
+
+func (mr *MockPurgeRepo) DeleteExpiredAuthorizationCodes(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return mr.delete(&mr.Codes, before, limit)
+}
+
+func (mr *MockPurgeRepo) DeleteExpiredAssertions(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return mr.delete(&mr.Assertions, before, limit)
+}
+
+func (mr *MockPurgeRepo) DeleteDeliveredWebhooks(ctx context.Context, before time.Time, limit int) (int64, error) {
+	return mr.delete(&mr.Webhooks, before, limit)
+}
+
+// deliveries have retention of their own, so Before is left as it is
+func (mr *MockPurgeRepo) DeleteWebhookDeliveries(ctx context.Context, before time.Time, limit int) (int64, error) {
+	mr.DeliveriesBefore = before
+	return mr.delete(&mr.Deliveries, mr.Before, limit)
+}
+
+func TestPurgeService_Purge(t *testing.T) {
+	t.Run("Deletes in batches", func(t *testing.T) {
+		mockRepo := &MockPurgeRepo{Sessions: 25, Codes: 3, Assertions: 10, Webhooks: 5, Deliveries: 12}
+		service := services.NewPurgeService(mockRepo, time.Hour, 24*time.Hour, 10)
+
+		result, err := service.Purge(context.Background())
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if result.Sessions != 25 || result.AuthorizationCodes != 3 || result.ClientAssertions != 10 || result.Webhooks != 5 ||
+			result.WebhookDeliveries != 12 || result.Total() != 55 {
+			t.Fatalf("unexpected result: %+v", result)
+		}
+		//codes: 1, sessions: 3, assertions: 2 (the last batch is empty), webhooks: 1, deliveries: 2
+		if mockRepo.Calls != 9 {
+			t.Fatalf("expected 9 batches, got %d", mockRepo.Calls)
+		}
+		if d := time.Until(mockRepo.Before); d > -time.Hour+time.Minute || d < -time.Hour-time.Minute {
+			t.Fatalf("rows expired within retention must be kept, cutoff %v", mockRepo.Before)
+		}
+		if d := time.Until(mockRepo.DeliveriesBefore); d > -24*time.Hour+time.Minute || d < -24*time.Hour-time.Minute {
+			t.Fatalf("deliveries within their retention must be kept, cutoff %v", mockRepo.DeliveriesBefore)
+		}
+	})
+
+	t.Run("Stops when context is cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		mockRepo := &MockPurgeRepo{Sessions: 100, Cancel: cancel, CancelAfter: 2}
+		service := services.NewPurgeService(mockRepo, time.Hour, 24*time.Hour, 10)
+
+		result, err := service.Purge(ctx)
+		if !errors.Is(err, context.Canceled) {
+			t.Fatalf("expected context.Canceled, got %v", err)
+		}
+		if result.Sessions != 10 || mockRepo.Calls != 2 {
+			t.Fatalf("expected purge to stop after the current batch, got %+v in %d calls", result, mockRepo.Calls)
+		}
+	})
+}
@@ -0,0 +1,35 @@
+package services
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/superdumb33/auth-service-test/internal/services")
+
+// records err on span and ends it; meant to be deferred with a named error result
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// bcrypt is slow on purpose, so its calls get spans of their own
+func hashRefreshToken(ctx context.Context, token string) ([]byte, error) {
+	_, span := tracer.Start(ctx, "bcrypt.GenerateFromPassword")
+	defer span.End()
+
+	return GenerateBCryptHash(token)
+}
+
+func verifyRefreshToken(ctx context.Context, token, hash string) error {
+	_, span := tracer.Start(ctx, "bcrypt.CompareHashAndPassword")
+	defer span.End()
+
+	return VerifyRefreshToken(token, hash)
+}
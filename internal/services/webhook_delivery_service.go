@@ -0,0 +1,74 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+// deliveries are listed by pages of at most that size
+const MaxDeliveryPageSize = 100
+
+type WebhookDeliveryRepo interface {
+	GetByID(ctx context.Context, id uuid.UUID) (*entities.WebhookDelivery, error)
+	List(ctx context.Context, params entities.WebhookDeliveryListParams) ([]*entities.WebhookDelivery, int, error)
+}
+
+type OutboxRequeuer interface {
+	//makes dead or failed message pending and due now, keeping its attempts; ErrConflict if it's delivered or being delivered,
+	//ErrNotFound if it's purged
+	Requeue(ctx context.Context, id uuid.UUID) error
+}
+
+// inspection and replay of webhook delivery attempts
+type WebhookDeliveryService struct {
+	repo   WebhookDeliveryRepo
+	outbox OutboxRequeuer
+}
+
+func NewWebhookDeliveryService(repo WebhookDeliveryRepo, outbox OutboxRequeuer) *WebhookDeliveryService {
+	return &WebhookDeliveryService{repo: repo, outbox: outbox}
+}
+
+func (ds *WebhookDeliveryService) Get(ctx context.Context, id uuid.UUID) (*entities.WebhookDelivery, error) {
+	return ds.repo.GetByID(ctx, id)
+}
+
+// returns page of deliveries matching params, newest first, and the number of matching ones
+func (ds *WebhookDeliveryService) List(ctx context.Context, params entities.WebhookDeliveryListParams) ([]*entities.WebhookDelivery, int, error) {
+	const op = "service:ListWebhookDeliveries"
+	if params.Limit < 1 || params.Limit > MaxDeliveryPageSize || params.Offset < 0 ||
+		(params.Event != "" && !slices.Contains(entities.EventTypes, params.Event)) ||
+		(params.Status != "" && params.Status != entities.DeliverySucceeded && params.Status != entities.DeliveryFailed) {
+		return nil, 0, fmt.Errorf("%s:%w", op, entities.ErrBadRequest)
+	}
+
+	deliveries, total, err := ds.repo.List(ctx, params)
+	if err != nil {
+		return nil, 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return deliveries, total, nil
+}
+
+// sends the message of a failed delivery again if it's dead or waits for a retry; a dead message gets a single attempt more.
+// ErrBadRequest if the delivery succeeded, ErrConflict if the message is delivered since or a dispatcher is delivering it,
+// ErrNotFound if it's purged already
+func (ds *WebhookDeliveryService) Replay(ctx context.Context, id uuid.UUID) (*entities.WebhookDelivery, error) {
+	const op = "service:ReplayWebhookDelivery"
+	delivery, err := ds.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if delivery.Status != entities.DeliveryFailed {
+		return nil, fmt.Errorf("%s:only failed deliveries are replayed:%w", op, entities.ErrBadRequest)
+	}
+	if err := ds.outbox.Requeue(ctx, delivery.OutboxID); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return delivery, nil
+}
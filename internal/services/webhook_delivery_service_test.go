@@ -0,0 +1,112 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+type MockWebhookDeliveryRepo struct {
+	Deliveries map[uuid.UUID]*entities.WebhookDelivery
+	Params     entities.WebhookDeliveryListParams
+}
+
+func (mr *MockWebhookDeliveryRepo) GetByID(ctx context.Context, id uuid.UUID) (*entities.WebhookDelivery, error) {
+	delivery, ok := mr.Deliveries[id]
+	if !ok {
+		return nil, entities.ErrNotFound
+	}
+	return delivery, nil
+}
+
+func (mr *MockWebhookDeliveryRepo) List(ctx context.Context, params entities.WebhookDeliveryListParams) ([]*entities.WebhookDelivery, int, error) {
+	mr.Params = params
+	return []*entities.WebhookDelivery{}, 0, nil
+}
+
+// holds messages still in the outbox, true if the message is dead or waits for a retry and isn't leased
+type MockOutboxRequeuer struct {
+	Messages map[uuid.UUID]bool
+	Requeued []uuid.UUID
+}
+
+func (mr *MockOutboxRequeuer) Requeue(ctx context.Context, id uuid.UUID) error {
+	requeueable, ok := mr.Messages[id]
+	if !ok {
+		return entities.ErrNotFound
+	}
+	if !requeueable {
+		return entities.ErrConflict
+	}
+	mr.Requeued = append(mr.Requeued, id)
+	return nil
+}
+
+func TestWebhookDeliveryService_List(t *testing.T) {
+	mockRepo := &MockWebhookDeliveryRepo{}
+	service := services.NewWebhookDeliveryService(mockRepo, &MockOutboxRequeuer{})
+	userID := uuid.New()
+
+	params := entities.WebhookDeliveryListParams{Limit: 20, UserID: &userID, Event: entities.EventSessionIPChanged,
+		Status: entities.DeliveryFailed}
+	if _, _, err := service.List(context.Background(), params); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if mockRepo.Params != params {
+		t.Fatalf("expected params to be passed as they are, got %+v", mockRepo.Params)
+	}
+
+	cases := map[string]entities.WebhookDeliveryListParams{
+		"zero limit":      {},
+		"limit too large": {Limit: services.MaxDeliveryPageSize + 1},
+		"negative offset": {Limit: 20, Offset: -1},
+		"unknown event":   {Limit: 20, Event: "user.deleted"},
+		"unknown status":  {Limit: 20, Status: "dead"},
+	}
+	for name, params := range cases {
+		if _, _, err := service.List(context.Background(), params); !errors.Is(err, entities.ErrBadRequest) {
+			t.Fatalf("%s: expected ErrBadRequest, got %v", name, err)
+		}
+	}
+}
+
+func TestWebhookDeliveryService_Replay(t *testing.T) {
+	failed := &entities.WebhookDelivery{ID: uuid.New(), OutboxID: uuid.New(), Status: entities.DeliveryFailed}
+	succeeded := &entities.WebhookDelivery{ID: uuid.New(), OutboxID: uuid.New(), Status: entities.DeliverySucceeded}
+	purged := &entities.WebhookDelivery{ID: uuid.New(), OutboxID: uuid.New(), Status: entities.DeliveryFailed}
+	//message is being delivered by a dispatcher, or is delivered by a retry already
+	busy := &entities.WebhookDelivery{ID: uuid.New(), OutboxID: uuid.New(), Status: entities.DeliveryFailed}
+	mockRepo := &MockWebhookDeliveryRepo{Deliveries: map[uuid.UUID]*entities.WebhookDelivery{
+		failed.ID: failed, succeeded.ID: succeeded, purged.ID: purged, busy.ID: busy,
+	}}
+	outbox := &MockOutboxRequeuer{Messages: map[uuid.UUID]bool{failed.OutboxID: true, succeeded.OutboxID: true, busy.OutboxID: false}}
+	service := services.NewWebhookDeliveryService(mockRepo, outbox)
+	ctx := context.Background()
+
+	if _, err := service.Replay(ctx, failed.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(outbox.Requeued) != 1 || outbox.Requeued[0] != failed.OutboxID {
+		t.Fatalf("expected message of the delivery to be requeued, got %v", outbox.Requeued)
+	}
+
+	if _, err := service.Replay(ctx, succeeded.ID); !errors.Is(err, entities.ErrBadRequest) {
+		t.Fatalf("expected ErrBadRequest for succeeded delivery, got %v", err)
+	}
+	if _, err := service.Replay(ctx, busy.ID); !errors.Is(err, entities.ErrConflict) {
+		t.Fatalf("expected ErrConflict for message being delivered, got %v", err)
+	}
+	if _, err := service.Replay(ctx, purged.ID); !errors.Is(err, entities.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for purged message, got %v", err)
+	}
+	if _, err := service.Replay(ctx, uuid.New()); !errors.Is(err, entities.ErrNotFound) {
+		t.Fatalf("expected ErrNotFound for unknown delivery, got %v", err)
+	}
+	if len(outbox.Requeued) != 1 {
+		t.Fatalf("expected nothing else to be requeued, got %v", outbox.Requeued)
+	}
+}
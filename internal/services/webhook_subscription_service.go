@@ -0,0 +1,217 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"slices"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+)
+
+// shorter secrets are rejected, generated ones are longer
+const minWebhookSecretLength = 16
+
+// name of the subscription created from WEBHOOK_URL
+const LegacyWebhookSubscriptionName = "WEBHOOK_URL"
+
+type WebhookSubscriptionRepo interface {
+	//runs fn in a transaction; repo methods called with ctx passed to fn take part in it
+	InTx(ctx context.Context, fn func(ctx context.Context) error) error
+	//keeps subscriptions from being created or changed by others until the end of transaction
+	Lock(ctx context.Context) error
+	Create(ctx context.Context, sub *entities.WebhookSubscription) error
+	GetByID(ctx context.Context, id uuid.UUID) (*entities.WebhookSubscription, error)
+	List(ctx context.Context) ([]*entities.WebhookSubscription, error)
+	//saves settings of the subscription; ErrNotFound if there's no such subscription
+	Update(ctx context.Context, sub *entities.WebhookSubscription) error
+	Delete(ctx context.Context, id uuid.UUID) error
+	//webhooks queued for WEBHOOK_URL before subscriptions were introduced have no subscription
+	AttachUnsubscribed(ctx context.Context, id uuid.UUID) (int64, error)
+	CountUnsubscribed(ctx context.Context) (int64, error)
+}
+
+// settings of a subscription; nil ones are set to defaults on create and left as they are on update
+type WebhookSubscriptionParams struct {
+	Name *string
+	URL  *string
+	//generated on create if nil or empty
+	Secret *string
+	//empty for every event
+	EventTypes *[]entities.EventType
+	//legacy by default
+	Format *entities.WebhookFormat
+	//true by default
+	Enabled *bool
+}
+
+type WebhookSubscriptionService struct {
+	repo WebhookSubscriptionRepo
+}
+
+func NewWebhookSubscriptionService(repo WebhookSubscriptionRepo) *WebhookSubscriptionService {
+	return &WebhookSubscriptionService{repo: repo}
+}
+
+// creates subscription, enabled and receiving every event unless params say otherwise
+func (ss *WebhookSubscriptionService) Create(ctx context.Context, params WebhookSubscriptionParams) (*entities.WebhookSubscription, error) {
+	const op = "service:CreateWebhookSubscription"
+	sub := &entities.WebhookSubscription{Enabled: true, EventTypes: []entities.EventType{}, Format: entities.WebhookFormatLegacy}
+	if params.Secret == nil || *params.Secret == "" {
+		secret, err := generateWebhookSecret()
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		params.Secret = &secret
+	}
+	if err := applyWebhookSubscriptionParams(sub, params); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if err := ss.repo.Create(ctx, sub); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return sub, nil
+}
+
+func (ss *WebhookSubscriptionService) Get(ctx context.Context, id uuid.UUID) (*entities.WebhookSubscription, error) {
+	return ss.repo.GetByID(ctx, id)
+}
+
+func (ss *WebhookSubscriptionService) List(ctx context.Context) ([]*entities.WebhookSubscription, error) {
+	return ss.repo.List(ctx)
+}
+
+// changes the given settings of subscription; a changed URL or secret applies to retries of queued webhooks as well
+func (ss *WebhookSubscriptionService) Update(ctx context.Context, id uuid.UUID, params WebhookSubscriptionParams) (*entities.WebhookSubscription, error) {
+	const op = "service:UpdateWebhookSubscription"
+	sub, err := ss.repo.GetByID(ctx, id)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if err := applyWebhookSubscriptionParams(sub, params); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if err := ss.repo.Update(ctx, sub); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return sub, nil
+}
+
+// deletes subscription; webhooks queued for it are dropped
+func (ss *WebhookSubscriptionService) Delete(ctx context.Context, id uuid.UUID) error {
+	return ss.repo.Delete(ctx, id)
+}
+
+// upgrade path from WEBHOOK_URL and WEBHOOK_SECRET: creates subscription to session.ip_changed with that URL and secret unless
+// there is one with the URL or imported before, even if changed since, and attaches webhooks queued for WEBHOOK_URL to it
+// if it takes their event, the rest are dropped.
+// Returns the subscription, whether it's been created, and the number of attached webhooks; safe to run on every start
+func (ss *WebhookSubscriptionService) ImportLegacyURL(ctx context.Context, rawURL, secret string) (sub *entities.WebhookSubscription,
+	created bool, attached int64, err error) {
+	const op = "service:ImportLegacyWebhookURL"
+	//the lock keeps instances started at once from creating a subscription each
+	err = ss.repo.InTx(ctx, func(ctx context.Context) error {
+		if err := ss.repo.Lock(ctx); err != nil {
+			return err
+		}
+		subs, err := ss.repo.List(ctx)
+		if err != nil {
+			return err
+		}
+		for _, existing := range subs {
+			if existing.URL == rawURL || existing.Name == LegacyWebhookSubscriptionName {
+				sub = existing
+				break
+			}
+		}
+		if sub == nil {
+			//WEBHOOK_URL got nothing but IP changes
+			sub = &entities.WebhookSubscription{Name: LegacyWebhookSubscriptionName, Enabled: true,
+				EventTypes: []entities.EventType{entities.EventSessionIPChanged}, Format: entities.WebhookFormatLegacy}
+			if err := applyWebhookSubscriptionParams(sub, WebhookSubscriptionParams{URL: &rawURL}); err != nil {
+				return err
+			}
+			//receivers verify webhooks with the secret they already have, so it isn't held to the length of new ones
+			if secret == "" {
+				return fmt.Errorf("missing secret:%w", entities.ErrBadRequest)
+			}
+			sub.Secret = secret
+			if err := ss.repo.Create(ctx, sub); err != nil {
+				return err
+			}
+			created = true
+		}
+		attached, err = ss.repo.AttachUnsubscribed(ctx, sub.ID)
+		return err
+	})
+	if err != nil {
+		return nil, false, 0, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return sub, created, attached, nil
+}
+
+// returns number of webhooks queued for WEBHOOK_URL that wait for ImportLegacyURL
+func (ss *WebhookSubscriptionService) CountUnsubscribed(ctx context.Context) (int64, error) {
+	return ss.repo.CountUnsubscribed(ctx)
+}
+
+// sets non-nil params to sub, returns ErrBadRequest if any of them is invalid
+func applyWebhookSubscriptionParams(sub *entities.WebhookSubscription, params WebhookSubscriptionParams) error {
+	if params.Name != nil {
+		sub.Name = *params.Name
+	}
+	if params.URL != nil {
+		parsed, err := url.Parse(*params.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			return fmt.Errorf("invalid url:%w", entities.ErrBadRequest)
+		}
+		sub.URL = *params.URL
+	}
+	if sub.URL == "" {
+		return fmt.Errorf("missing url:%w", entities.ErrBadRequest)
+	}
+	if params.Secret != nil {
+		if len(*params.Secret) < minWebhookSecretLength {
+			return fmt.Errorf("secret too short:%w", entities.ErrBadRequest)
+		}
+		sub.Secret = *params.Secret
+	}
+	if params.EventTypes != nil {
+		eventTypes := []entities.EventType{}
+		for _, eventType := range *params.EventTypes {
+			if !slices.Contains(entities.EventTypes, eventType) {
+				return fmt.Errorf("unknown event type %q:%w", eventType, entities.ErrBadRequest)
+			}
+			if !slices.Contains(eventTypes, eventType) {
+				eventTypes = append(eventTypes, eventType)
+			}
+		}
+		sub.EventTypes = eventTypes
+	}
+	if params.Format != nil {
+		if !slices.Contains(entities.WebhookFormats, *params.Format) {
+			return fmt.Errorf("unknown format %q:%w", *params.Format, entities.ErrBadRequest)
+		}
+		sub.Format = *params.Format
+	}
+	if params.Enabled != nil {
+		sub.Enabled = *params.Enabled
+	}
+
+	return nil
+}
+
+func generateWebhookSecret() (string, error) {
+	randBytes := make([]byte, 32)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+
+	return "whsec_" + base64.RawURLEncoding.EncodeToString(randBytes), nil
+}
@@ -0,0 +1,209 @@
+package services_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/superdumb33/auth-service-test/internal/entities"
+	"github.com/superdumb33/auth-service-test/internal/services"
+)
+
+type MockWebhookSubscriptionRepo struct {
+	Subscriptions map[uuid.UUID]*entities.WebhookSubscription
+	//events of webhooks queued for WEBHOOK_URL and not attached yet
+	Unsubscribed []entities.EventType
+	//subscription the webhooks were attached to
+	AttachedTo uuid.UUID
+}
+
+func (mr *MockWebhookSubscriptionRepo) InTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return fn(ctx)
+}
+
+func (mr *MockWebhookSubscriptionRepo) Lock(ctx context.Context) error {
+	return nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) AttachUnsubscribed(ctx context.Context, id uuid.UUID) (int64, error) {
+	var attached int64
+	for _, event := range mr.Unsubscribed {
+		if mr.Subscriptions[id].Accepts(event) {
+			attached++
+			mr.AttachedTo = id
+		}
+	}
+	mr.Unsubscribed = nil
+	return attached, nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) CountUnsubscribed(ctx context.Context) (int64, error) {
+	return int64(len(mr.Unsubscribed)), nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) Create(ctx context.Context, sub *entities.WebhookSubscription) error {
+	sub.ID = uuid.New()
+	mr.Subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) GetByID(ctx context.Context, id uuid.UUID) (*entities.WebhookSubscription, error) {
+	sub, ok := mr.Subscriptions[id]
+	if !ok {
+		return nil, entities.ErrNotFound
+	}
+	copied := *sub
+	return &copied, nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) List(ctx context.Context) ([]*entities.WebhookSubscription, error) {
+	subs := []*entities.WebhookSubscription{}
+	for _, sub := range mr.Subscriptions {
+		subs = append(subs, sub)
+	}
+	return subs, nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) Update(ctx context.Context, sub *entities.WebhookSubscription) error {
+	if _, ok := mr.Subscriptions[sub.ID]; !ok {
+		return entities.ErrNotFound
+	}
+	mr.Subscriptions[sub.ID] = sub
+	return nil
+}
+
+func (mr *MockWebhookSubscriptionRepo) Delete(ctx context.Context, id uuid.UUID) error {
+	if _, ok := mr.Subscriptions[id]; !ok {
+		return entities.ErrNotFound
+	}
+	delete(mr.Subscriptions, id)
+	return nil
+}
+
+func TestWebhookSubscriptionService(t *testing.T) {
+	ctx := context.Background()
+	ptr := func(s string) *string { return &s }
+	newService := func() (*services.WebhookSubscriptionService, *MockWebhookSubscriptionRepo) {
+		mockRepo := &MockWebhookSubscriptionRepo{Subscriptions: map[uuid.UUID]*entities.WebhookSubscription{}}
+		return services.NewWebhookSubscriptionService(mockRepo), mockRepo
+	}
+
+	t.Run("Create with defaults", func(t *testing.T) {
+		service, _ := newService()
+		sub, err := service.Create(ctx, services.WebhookSubscriptionParams{URL: ptr("https://security.example.com/hooks")})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !sub.Enabled || len(sub.EventTypes) != 0 || !strings.HasPrefix(sub.Secret, "whsec_") ||
+			sub.Format != entities.WebhookFormatLegacy {
+			t.Fatalf("expected enabled legacy subscription to every event with generated secret, got %+v", sub)
+		}
+		if !sub.Accepts(entities.EventTokenReused) {
+			t.Fatal("expected subscription to accept every event")
+		}
+	})
+
+	t.Run("Create invalid", func(t *testing.T) {
+		service, mockRepo := newService()
+		cases := map[string]services.WebhookSubscriptionParams{
+			"missing url":        {},
+			"relative url":       {URL: ptr("/hooks")},
+			"unsupported scheme": {URL: ptr("ftp://example.com/hooks")},
+			"short secret":       {URL: ptr("https://example.com/hooks"), Secret: ptr("123")},
+			"unknown event": {URL: ptr("https://example.com/hooks"),
+				EventTypes: &[]entities.EventType{entities.EventSessionIssued, "user.deleted"}},
+			"unknown format": {URL: ptr("https://example.com/hooks"), Format: func() *entities.WebhookFormat {
+				format := entities.WebhookFormat("xml")
+				return &format
+			}()},
+		}
+		for name, params := range cases {
+			if _, err := service.Create(ctx, params); !errors.Is(err, entities.ErrBadRequest) {
+				t.Fatalf("%s: expected ErrBadRequest, got %v", name, err)
+			}
+		}
+		if len(mockRepo.Subscriptions) != 0 {
+			t.Fatalf("expected nothing to be created, got %v", mockRepo.Subscriptions)
+		}
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		service, _ := newService()
+		created, err := service.Create(ctx, services.WebhookSubscriptionParams{
+			Name: ptr("analytics"), URL: ptr("https://analytics.example.com/hooks"),
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+
+		disabled, format := false, entities.WebhookFormatCloudEventsBinary
+		updated, err := service.Update(ctx, created.ID, services.WebhookSubscriptionParams{
+			EventTypes: &[]entities.EventType{entities.EventSessionIssued, entities.EventSessionIssued},
+			Format:     &format,
+			Enabled:    &disabled,
+		})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if updated.Name != "analytics" || updated.URL != created.URL || updated.Secret != created.Secret {
+			t.Fatalf("expected absent fields to stay the same, got %+v", updated)
+		}
+		if updated.Enabled || updated.Format != format || len(updated.EventTypes) != 1 || !updated.Accepts(entities.EventSessionIssued) ||
+			updated.Accepts(entities.EventTokenReused) {
+			t.Fatalf("expected disabled binary CloudEvents subscription to session.issued only, got %+v", updated)
+		}
+
+		if _, err := service.Update(ctx, uuid.New(), services.WebhookSubscriptionParams{}); !errors.Is(err, entities.ErrNotFound) {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("Import WEBHOOK_URL", func(t *testing.T) {
+		service, mockRepo := newService()
+		mockRepo.Unsubscribed = []entities.EventType{entities.EventSessionIPChanged, entities.EventSessionIPChanged,
+			entities.EventTokenReused}
+
+		sub, created, attached, err := service.ImportLegacyURL(ctx, "https://legacy.example.com/hooks", "short-secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !created || attached != 2 || mockRepo.AttachedTo != sub.ID {
+			t.Fatalf("expected subscription to be created and queued IP changes attached to it, got %v, %d", created, attached)
+		}
+		if sub.Name != services.LegacyWebhookSubscriptionName || sub.Secret != "short-secret" || !sub.Enabled ||
+			sub.Format != entities.WebhookFormatLegacy || !sub.Accepts(entities.EventSessionIPChanged) ||
+			sub.Accepts(entities.EventTokenReused) {
+			t.Fatalf("expected enabled legacy subscription to IP changes only with the old secret, got %+v", sub)
+		}
+
+		//every start imports it again
+		again, created, attached, err := service.ImportLegacyURL(ctx, "https://legacy.example.com/hooks", "short-secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if created || attached != 0 || again.ID != sub.ID || len(mockRepo.Subscriptions) != 1 {
+			t.Fatalf("expected the existing subscription to be reused, got %d subscriptions", len(mockRepo.Subscriptions))
+		}
+		//URL changed with the admin API while WEBHOOK_URL is still set
+		newURL := "https://new.example.com/hooks"
+		if _, err := service.Update(ctx, sub.ID, services.WebhookSubscriptionParams{URL: &newURL}); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if again, created, _, _ = service.ImportLegacyURL(ctx, "https://legacy.example.com/hooks", "short-secret"); created || again.ID != sub.ID {
+			t.Fatalf("expected the imported subscription to be reused, got %d subscriptions", len(mockRepo.Subscriptions))
+		}
+	})
+
+	t.Run("Import WEBHOOK_URL without secret", func(t *testing.T) {
+		service, mockRepo := newService()
+		mockRepo.Unsubscribed = []entities.EventType{entities.EventSessionIPChanged}
+		if _, _, _, err := service.ImportLegacyURL(ctx, "https://legacy.example.com/hooks", ""); !errors.Is(err, entities.ErrBadRequest) {
+			t.Fatalf("expected ErrBadRequest, got %v", err)
+		}
+		if waiting, _ := service.CountUnsubscribed(ctx); waiting != 1 {
+			t.Fatalf("expected queued webhooks to keep waiting, got %d", waiting)
+		}
+	})
+}
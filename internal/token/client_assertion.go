@@ -0,0 +1,120 @@
+package token
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// assertions living longer than that are rejected, so used jti don't have to be kept for long
+const maxAssertionTTL = 5 * time.Minute
+
+var ErrInvalidAssertion = errors.New("invalid client assertion")
+
+// verified RFC 7523 client assertion
+type ClientAssertion struct {
+	ClientID  string
+	JTI       string
+	ExpiresAt time.Time
+}
+
+// returns client ID claimed by the assertion without verifying it; it's only used to find the key to verify assertion with
+func AssertionClientID(assertion string) (string, error) {
+	claims := jwt.MapClaims{}
+	if _, _, err := new(jwt.Parser).ParseUnverified(assertion, claims); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+	iss, _ := claims["iss"].(string)
+	if iss == "" {
+		return "", fmt.Errorf("%w: empty iss", ErrInvalidAssertion)
+	}
+
+	return iss, nil
+}
+
+// verifies assertion signed with the private key of the client publicKeyPEM belongs to;
+// aud must match one of audiences, iss and sub must both be equal to clientID
+func ParseClientAssertion(assertion, clientID, publicKeyPEM string, audiences []string) (*ClientAssertion, error) {
+	publicKey, err := parsePublicKeyPEM([]byte(publicKeyPEM))
+	if err != nil {
+		return nil, err
+	}
+
+	claims := jwt.MapClaims{}
+	_, err = jwt.ParseWithClaims(assertion, claims, func(t *jwt.Token) (interface{}, error) {
+		if !keyFits(t.Method, publicKey) {
+			return nil, errors.New("unprocessable signing method")
+		}
+		return publicKey, nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidAssertion, err)
+	}
+
+	if iss, _ := claims["iss"].(string); iss != clientID {
+		return nil, fmt.Errorf("%w: iss mismatch", ErrInvalidAssertion)
+	}
+	if sub, _ := claims["sub"].(string); sub != clientID {
+		return nil, fmt.Errorf("%w: sub mismatch", ErrInvalidAssertion)
+	}
+	audMatches := false
+	for _, aud := range audiences {
+		if claims.VerifyAudience(aud, true) {
+			audMatches = true
+			break
+		}
+	}
+	if !audMatches {
+		return nil, fmt.Errorf("%w: aud mismatch", ErrInvalidAssertion)
+	}
+	jti, _ := claims["jti"].(string)
+	if jti == "" {
+		return nil, fmt.Errorf("%w: empty jti", ErrInvalidAssertion)
+	}
+	exp, ok := claims["exp"].(float64)
+	if !ok {
+		return nil, fmt.Errorf("%w: empty exp", ErrInvalidAssertion)
+	}
+	expiresAt := time.Unix(int64(exp), 0)
+	if time.Until(expiresAt) > maxAssertionTTL {
+		return nil, fmt.Errorf("%w: exp is too far in the future", ErrInvalidAssertion)
+	}
+
+	return &ClientAssertion{ClientID: clientID, JTI: jti, ExpiresAt: expiresAt}, nil
+}
+
+func parsePublicKeyPEM(pemBytes []byte) (interface{}, error) {
+	if key, err := jwt.ParseRSAPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseECPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+	if key, err := jwt.ParseEdPublicKeyFromPEM(pemBytes); err == nil {
+		return key, nil
+	}
+
+	return nil, fmt.Errorf("%w: unsupported public key", ErrInvalidAssertion)
+}
+
+// checks that token is signed with the algorithm matching key type, so the key can't be used as HMAC secret
+func keyFits(method jwt.SigningMethod, key interface{}) bool {
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		_, ok := key.(*rsa.PublicKey)
+		return ok
+	case *jwt.SigningMethodECDSA:
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		return ok && ecKey.Curve.Params().BitSize == m.CurveBits
+	case *jwt.SigningMethodEd25519:
+		_, ok := key.(ed25519.PublicKey)
+		return ok
+	default:
+		return false
+	}
+}
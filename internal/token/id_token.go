@@ -0,0 +1,66 @@
+package token
+
+import (
+	"time"
+)
+
+// iss claim of access and ID tokens; set with SetIssuer
+var issuer string
+
+func SetIssuer(iss string) {
+	issuer = iss
+}
+
+func Issuer() string {
+	return issuer
+}
+
+// claims of OpenID Connect ID token (OpenID Connect Core 1.0 section 2)
+type IDTokenClaims struct {
+	//user ID
+	Subject string
+	//client ID
+	Audience string
+	//nonce of authorization request; omitted if empty
+	Nonce    string
+	AuthTime time.Time
+	//session ID, the same as jti of access token issued along with ID token
+	SessionID string
+}
+
+// fails with ErrNoAsymmetricKey if there is no active asymmetric key, see IDTokensSupported
+func GenerateIDToken(idClaims IDTokenClaims, ttl time.Duration) (string, error) {
+	claims := baseClaims(ttl)
+	claims["sub"] = idClaims.Subject
+	claims["aud"] = idClaims.Audience
+	claims["auth_time"] = idClaims.AuthTime.Unix()
+	claims["sid"] = idClaims.SessionID
+	if idClaims.Nonce != "" {
+		claims["nonce"] = idClaims.Nonce
+	}
+
+	return sign(claims, ttl, "JWT", true)
+}
+
+// reports whether ID tokens can be signed, i.e. there is an active asymmetric key
+func IDTokensSupported() bool {
+	return keyRing != nil && len(SigningAlgorithms()) > 0
+}
+
+// returns distinct algorithms of asymmetric keys ID tokens may currently be signed with
+func SigningAlgorithms() []string {
+	algs := []string{}
+	if keyRing == nil {
+		return algs
+	}
+	seen := map[string]bool{}
+	for _, key := range keyRing.keys {
+		if key.Status != KeyStatusActive || !key.usable(time.Now()) || key.PublicKey() == nil || seen[key.Method.Alg()] {
+			continue
+		}
+		seen[key.Method.Alg()] = true
+		algs = append(algs, key.Method.Alg())
+	}
+
+	return algs
+}
@@ -0,0 +1,163 @@
+package token
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrNoActiveKey = errors.New("no active signing key")
+	//ID tokens are verified by clients, so they are never signed with a shared HMAC secret
+	ErrNoAsymmetricKey = errors.New("no active asymmetric signing key")
+	ErrUnknownKey      = errors.New("unknown signing key")
+)
+
+// KeyRing holds every key tokens may be signed with, in the order they were configured
+type KeyRing struct {
+	keys []*SigningKey
+	byID map[string]*SigningKey
+}
+
+func NewKeyRing(keys ...*SigningKey) (*KeyRing, error) {
+	const op = "token:NewKeyRing"
+	ring := &KeyRing{byID: make(map[string]*SigningKey, len(keys))}
+	hasActive := false
+	for _, key := range keys {
+		if key.ID == "" {
+			return nil, fmt.Errorf("%s:empty key id", op)
+		}
+		if _, ok := ring.byID[key.ID]; ok {
+			return nil, fmt.Errorf("%s:duplicate key id %q", op, key.ID)
+		}
+		switch key.Status {
+		case KeyStatusActive:
+			hasActive = true
+		case KeyStatusVerifyOnly, KeyStatusRetired:
+		default:
+			return nil, fmt.Errorf("%s:unknown status %q of key %q", op, key.Status, key.ID)
+		}
+		ring.keys = append(ring.keys, key)
+		ring.byID[key.ID] = key
+	}
+	if !hasActive {
+		return nil, fmt.Errorf("%s:%w", op, ErrNoActiveKey)
+	}
+
+	return ring, nil
+}
+
+// returns active key to sign a token living for ttl with, only asymmetric ones if asymmetric is set;
+// key that expires first is preferred, so keys with successive NotAfter dates take turns without a restart
+func (kr *KeyRing) signingKey(ttl time.Duration, now time.Time, asymmetric bool) (*SigningKey, error) {
+	var picked *SigningKey
+	for _, key := range kr.keys {
+		if key.Status != KeyStatusActive || !key.usable(now) || (asymmetric && key.PublicKey() == nil) {
+			continue
+		}
+		//token must not outlive the key it's signed with
+		if !key.NotAfter.IsZero() && now.Add(ttl).After(key.NotAfter) {
+			continue
+		}
+		if picked == nil || (!key.NotAfter.IsZero() && (picked.NotAfter.IsZero() || key.NotAfter.Before(picked.NotAfter))) {
+			picked = key
+		}
+	}
+	if picked == nil && asymmetric {
+		return nil, ErrNoAsymmetricKey
+	}
+	if picked == nil {
+		return nil, ErrNoActiveKey
+	}
+
+	return picked, nil
+}
+
+// returns key to verify a token with; tokens without kid were issued before key rotation was introduced
+// and are verified with the first usable key of the same algorithm
+func (kr *KeyRing) verificationKey(kid, alg string, now time.Time) (*SigningKey, error) {
+	if kid == "" {
+		for _, key := range kr.keys {
+			if key.usable(now) && key.Method.Alg() == alg {
+				return key, nil
+			}
+		}
+		return nil, ErrUnknownKey
+	}
+
+	key, ok := kr.byID[kid]
+	if !ok || !key.usable(now) {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownKey, kid)
+	}
+	//alg is pinned to the key to prevent algorithm confusion
+	if key.Method.Alg() != alg {
+		return nil, errors.New("unprocessable signing method")
+	}
+
+	return key, nil
+}
+
+// returns keys that aren't retired or expired
+func (kr *KeyRing) UsableKeys(now time.Time) []*SigningKey {
+	keys := make([]*SigningKey, 0, len(kr.keys))
+	for _, key := range kr.keys {
+		if key.usable(now) {
+			keys = append(keys, key)
+		}
+	}
+
+	return keys
+}
+
+type keyFile struct {
+	Keys []struct {
+		ID             string    `json:"kid"`
+		Alg            string    `json:"alg"`
+		PrivateKeyPath string    `json:"private_key_path"`
+		Secret         string    `json:"secret"`
+		Status         KeyStatus `json:"status"`
+		NotAfter       time.Time `json:"not_after"`
+	} `json:"keys"`
+}
+
+// loads key ring from JSON file; relative key paths are resolved from the working directory
+//
+//	{"keys": [{"kid": "2025-q3", "alg": "ES256", "private_key_path": "keys/2025-q3.pem", "status": "active", "not_after": "2026-01-01T00:00:00Z"}]}
+func LoadKeyRingFile(path string) (*KeyRing, error) {
+	const op = "token:LoadKeyRingFile"
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	var file keyFile
+	if err := json.Unmarshal(raw, &file); err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	keys := make([]*SigningKey, 0, len(file.Keys))
+	for _, k := range file.Keys {
+		var key *SigningKey
+		//retired keys may already be deleted from disk, their material is never used
+		if k.Status == KeyStatusRetired {
+			key = &SigningKey{Method: jwt.GetSigningMethod(k.Alg)}
+			if key.Method == nil {
+				return nil, fmt.Errorf("%s:%w: %s", op, ErrUnsupportedAlg, k.Alg)
+			}
+		} else {
+			key, err = LoadSigningKey(k.Alg, k.PrivateKeyPath, k.Secret)
+			if err != nil {
+				return nil, fmt.Errorf("%s:key %q:%w", op, k.ID, err)
+			}
+		}
+		key.ID = k.ID
+		key.Status = k.Status
+		key.NotAfter = k.NotAfter
+		keys = append(keys, key)
+	}
+
+	return NewKeyRing(keys...)
+}
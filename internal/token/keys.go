@@ -0,0 +1,195 @@
+package token
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+var (
+	ErrUnsupportedAlg = errors.New("unsupported signing algorithm")
+	ErrKeyNotInit     = errors.New("signing key is not initialized")
+)
+
+type KeyStatus string
+
+const (
+	//signs new tokens and verifies existing ones
+	KeyStatusActive KeyStatus = "active"
+	//only verifies tokens; used for keys being rotated in or out
+	KeyStatusVerifyOnly KeyStatus = "verify-only"
+	//neither signs nor verifies; kept in key ring for the record
+	KeyStatusRetired KeyStatus = "retired"
+)
+
+// SigningKey holds a key used to sign and verify access tokens
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	Status KeyStatus
+	//key is neither used nor published after NotAfter; zero value means no limit
+	NotAfter time.Time
+	private  interface{}
+	public   interface{}
+}
+
+// key ring used by GenerateAccessToken and ParseJWTToken; set with MustInitKeys, SetKeyRing or SetSigningKey
+var keyRing *KeyRing
+
+// where signing keys come from; KeysFile takes precedence over single key settings
+type KeySettings struct {
+	//JSON key ring for rotation
+	KeysFile string
+	Alg      string
+	//PEM private key of asymmetric algorithms
+	PrivateKeyPath string
+	//HMAC secret of HS* algorithms
+	Secret string
+	//kid of the single key; RFC 7638 thumbprint is used if empty
+	KeyID string
+}
+
+// it'll throw a panic if keys can't be loaded
+func MustInitKeys(settings KeySettings) {
+	if settings.KeysFile != "" {
+		ring, err := LoadKeyRingFile(settings.KeysFile)
+		if err != nil {
+			panic(err)
+		}
+		SetKeyRing(ring)
+		return
+	}
+
+	key, err := LoadSigningKey(settings.Alg, settings.PrivateKeyPath, settings.Secret)
+	if err != nil {
+		panic(err)
+	}
+	key.ID = settings.KeyID
+
+	SetSigningKey(key)
+}
+
+func SetKeyRing(ring *KeyRing) {
+	keyRing = ring
+}
+
+// replaces key ring with the one holding only the given key, marked as active
+func SetSigningKey(key *SigningKey) {
+	//thumbprint changes along with the key, so a new key gets a new kid; symmetric keys don't have one
+	if key.ID == "" {
+		key.ID = key.Thumbprint()
+	}
+	if key.ID == "" {
+		key.ID = "default"
+	}
+	key.Status = KeyStatusActive
+	ring, _ := NewKeyRing(key)
+	keyRing = ring
+}
+
+// loads signing key for the given algorithm; HMAC algorithms use secret, the rest read PEM private key from keyPath
+func LoadSigningKey(alg, keyPath, secret string) (*SigningKey, error) {
+	const op = "token:LoadSigningKey"
+	method := jwt.GetSigningMethod(alg)
+	if method == nil {
+		return nil, fmt.Errorf("%s:%w: %s", op, ErrUnsupportedAlg, alg)
+	}
+
+	if _, ok := method.(*jwt.SigningMethodHMAC); ok {
+		if secret == "" {
+			return nil, fmt.Errorf("%s:empty secret for %s", op, alg)
+		}
+		return &SigningKey{Method: method, private: []byte(secret), public: []byte(secret)}, nil
+	}
+
+	if keyPath == "" {
+		return nil, fmt.Errorf("%s:empty private key path for %s", op, alg)
+	}
+	pemBytes, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	return ParseSigningKeyPEM(method, pemBytes)
+}
+
+// parses PEM encoded private key and checks that it matches the signing method
+func ParseSigningKeyPEM(method jwt.SigningMethod, pemBytes []byte) (*SigningKey, error) {
+	const op = "token:ParseSigningKeyPEM"
+	switch m := method.(type) {
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS:
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		if private.N.BitLen() < 2048 {
+			return nil, fmt.Errorf("%s:RSA key must be at least 2048 bits", op)
+		}
+		return &SigningKey{Method: method, private: private, public: &private.PublicKey}, nil
+	case *jwt.SigningMethodECDSA:
+		private, err := jwt.ParseECPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		if private.Curve.Params().BitSize != m.CurveBits {
+			return nil, fmt.Errorf("%s:curve %s doesn't match %s", op, private.Curve.Params().Name, m.Alg())
+		}
+		return &SigningKey{Method: method, private: private, public: &private.PublicKey}, nil
+	case *jwt.SigningMethodEd25519:
+		private, err := jwt.ParseEdPrivateKeyFromPEM(pemBytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		edKey, ok := private.(ed25519.PrivateKey)
+		if !ok {
+			return nil, fmt.Errorf("%s:not an Ed25519 key", op)
+		}
+		return &SigningKey{Method: method, private: edKey, public: edKey.Public()}, nil
+	default:
+		return nil, fmt.Errorf("%s:%w: %s", op, ErrUnsupportedAlg, method.Alg())
+	}
+}
+
+// returns public part of the key; nil for symmetric keys, they must never be published
+func (k *SigningKey) PublicKey() crypto.PublicKey {
+	switch k.public.(type) {
+	case *rsa.PublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+		return k.public
+	default:
+		return nil
+	}
+}
+
+func (k *SigningKey) usable(now time.Time) bool {
+	return k.Status != KeyStatusRetired && (k.NotAfter.IsZero() || now.Before(k.NotAfter))
+}
+
+func curveName(curve elliptic.Curve) string {
+	switch curve {
+	case elliptic.P256():
+		return "P-256"
+	case elliptic.P384():
+		return "P-384"
+	case elliptic.P521():
+		return "P-521"
+	default:
+		return ""
+	}
+}
+
+// returns active key a token living for ttl would be signed with right now
+func CurrentSigningKey(ttl time.Duration) (*SigningKey, error) {
+	if keyRing == nil {
+		return nil, ErrKeyNotInit
+	}
+
+	return keyRing.signingKey(ttl, time.Now(), false)
+}
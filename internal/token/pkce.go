@@ -0,0 +1,49 @@
+package token
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"regexp"
+)
+
+// only S256 is supported, plain method gives no protection if the authorization request leaks
+const PKCEMethodS256 = "S256"
+
+// RFC 7636 section 4.1: 43 to 128 characters of [A-Z] / [a-z] / [0-9] / "-" / "." / "_" / "~"
+var pkceValuePattern = regexp.MustCompile(`^[A-Za-z0-9\-._~]{43,128}$`)
+
+// returns URL-safe random authorization code
+func GenerateAuthorizationCode() (string, error) {
+	var randBytes = make([]byte, 32)
+	if _, err := rand.Read(randBytes); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(randBytes), nil
+}
+
+// returns hex encoded sha256 of authorization code; codes are stored hashed only
+func AuthorizationCodeHash(code string) string {
+	sum := sha256.Sum256([]byte(code))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// checks code_challenge and code_verifier format
+func ValidPKCEValue(value string) bool {
+	return pkceValuePattern.MatchString(value)
+}
+
+// checks that S256 challenge was derived from verifier
+func VerifyPKCE(verifier, challenge string) bool {
+	if !ValidPKCEValue(verifier) {
+		return false
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	expected := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	return subtle.ConstantTimeCompare([]byte(expected), []byte(challenge)) == 1
+}
@@ -2,9 +2,10 @@ package token
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"errors"
-	"os"
 	"time"
 
 	"github.com/golang-jwt/jwt/v4"
@@ -18,14 +19,47 @@ type RefreshToken struct {
 	TokenID     string
 }
 
+// typ header of access tokens (RFC 9068), tells them apart from ID tokens signed with the same keys
+const AccessTokenType = "at+jwt"
+
+var ErrNotAccessToken = errors.New("not an access token")
+
 func GenerateAccessToken(jti string, ttl time.Duration) (string, error) {
+	claims := baseClaims(ttl)
+	claims["jti"] = jti
+
+	return sign(claims, ttl, AccessTokenType, false)
+}
+
+// returns exp, iat and iss claims shared by access and ID tokens; iss is omitted if issuer isn't set
+func baseClaims(ttl time.Duration) jwt.MapClaims {
+	now := time.Now()
 	claims := jwt.MapClaims{
-		"exp": time.Now().Add(ttl).Unix(),
-		"jti": jti,
+		"exp": now.Add(ttl).Unix(),
+		"iat": now.Unix(),
+	}
+	if issuer != "" {
+		claims["iss"] = issuer
+	}
+
+	return claims
+}
+
+// signs claims of a token living for ttl with the active key, asymmetric one if asymmetric is set;
+// kid header is set to ID of the key and typ header to typ
+func sign(claims jwt.MapClaims, ttl time.Duration, typ string, asymmetric bool) (string, error) {
+	if keyRing == nil {
+		return "", ErrKeyNotInit
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
+	key, err := keyRing.signingKey(ttl, time.Now(), asymmetric)
+	if err != nil {
+		return "", err
+	}
+	token := jwt.NewWithClaims(key.Method, claims)
+	token.Header["kid"] = key.ID
+	token.Header["typ"] = typ
 
-	return token.SignedString([]byte(os.Getenv("JWT_SECRET")))
+	return token.SignedString(key.private)
 }
 //returns base64 encoded refresh token
 func GenerateRefreshToken() (string, error) {
@@ -37,6 +71,13 @@ func GenerateRefreshToken() (string, error) {
 	return base64.StdEncoding.EncodeToString(randBytes), nil
 }
 
+// returns hex encoded sha256 of refresh token; refresh tokens are random, so a fast hash is enough to look them up by it
+func RefreshTokenLookupHash(refreshToken string) string {
+	sum := sha256.Sum256([]byte(refreshToken))
+
+	return hex.EncodeToString(sum[:])
+}
+
 // accepts raw token, returns bcrypt hash
 func GenerateBCryptHash(token string) ([]byte, error) {
 	if token == "" {
@@ -48,10 +89,15 @@ func GenerateBCryptHash(token string) ([]byte, error) {
 //if allowExpired = true, omits ErrTokenExpired error and returns token
 func ParseJWTToken(tokenString string, allowExpired bool) (*jwt.Token, error) {
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok || token.Method.Alg() != jwt.SigningMethodHS512.Alg() {
-			return nil, errors.New("unprocessable signing method")
+		if keyRing == nil {
+			return nil, ErrKeyNotInit
 		}
-		return []byte(os.Getenv("JWT_SECRET")), nil
+		kid, _ := token.Header["kid"].(string)
+		key, err := keyRing.verificationKey(kid, token.Method.Alg(), time.Now())
+		if err != nil {
+			return nil, err
+		}
+		return key.public, nil
 	})
 	if err != nil {
 		if errors.Is(err, jwt.ErrTokenExpired) && allowExpired {
@@ -64,6 +110,27 @@ func ParseJWTToken(tokenString string, allowExpired bool) (*jwt.Token, error) {
 	return token, nil
 }
 
+// same as ParseJWTToken, but fails with ErrNotAccessToken for any token other than access token, e.g. ID token
+func ParseAccessToken(tokenString string, allowExpired bool) (*jwt.Token, error) {
+	token, err := ParseJWTToken(tokenString, allowExpired)
+	if err != nil {
+		return nil, err
+	}
+	if typ, _ := token.Header["typ"].(string); typ != AccessTokenType && !legacyAccessToken(token) {
+		return nil, ErrNotAccessToken
+	}
+
+	return token, nil
+}
+
+// access tokens issued before typ header was set carry "JWT" typ and no aud claim, which ID tokens always have
+func legacyAccessToken(token *jwt.Token) bool {
+	typ, _ := token.Header["typ"].(string)
+	_, hasAudience := token.Claims.(jwt.MapClaims)["aud"]
+
+	return typ == "JWT" && !hasAudience
+}
+
 // accepts refreshToken, comparing it's bcrypt hash with storedHash; returns nil if hash matches
 func VerifyRefreshToken(refreshToken, storedHash string) error {
 	return bcrypt.CompareHashAndPassword([]byte(storedHash), []byte(refreshToken))
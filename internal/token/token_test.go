@@ -0,0 +1,231 @@
+package token_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+	"github.com/superdumb33/auth-service-test/internal/token"
+)
+
+func mustPEM(t *testing.T, key interface{}) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+}
+
+func TestSigningKeys(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, edKey, _ := ed25519.GenerateKey(rand.Reader)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		pem    []byte
+		kty    string
+	}{
+		{"RS256", jwt.SigningMethodRS256, mustPEM(t, rsaKey), "RSA"},
+		{"ES256", jwt.SigningMethodES256, mustPEM(t, ecKey), "EC"},
+		{"EdDSA", jwt.SigningMethodEdDSA, mustPEM(t, edKey), "OKP"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			key, err := token.ParseSigningKeyPEM(tt.method, tt.pem)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			token.SetSigningKey(key)
+
+			signed, err := token.GenerateAccessToken("jti", time.Minute)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			parsed, err := token.ParseJWTToken(signed, false)
+			if err != nil || !parsed.Valid {
+				t.Fatalf("expected valid token, got: %v", err)
+			}
+
+			jwks := token.PublicJWKS()
+			if len(jwks.Keys) != 1 || jwks.Keys[0].Kty != tt.kty || jwks.Keys[0].Alg != tt.method.Alg() {
+				t.Fatalf("unexpected jwks: %+v", jwks)
+			}
+		})
+	}
+
+	t.Run("Curve mismatch", func(t *testing.T) {
+		if _, err := token.ParseSigningKeyPEM(jwt.SigningMethodES384, mustPEM(t, ecKey)); err == nil {
+			t.Fatal("expected error for P-256 key used with ES384")
+		}
+	})
+
+	t.Run("HMAC key is not published", func(t *testing.T) {
+		key, err := token.LoadSigningKey("HS512", "", "secret")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		token.SetSigningKey(key)
+
+		if jwks := token.PublicJWKS(); len(jwks.Keys) != 0 {
+			t.Fatalf("expected empty jwks, got: %+v", jwks)
+		}
+	})
+
+	t.Run("Algorithm confusion", func(t *testing.T) {
+		hmacKey, _ := token.LoadSigningKey("HS256", "", "secret")
+		token.SetSigningKey(hmacKey)
+		forged, _ := token.GenerateAccessToken("jti", time.Minute)
+
+		rsaSigningKey, _ := token.ParseSigningKeyPEM(jwt.SigningMethodRS256, mustPEM(t, rsaKey))
+		token.SetSigningKey(rsaSigningKey)
+		if _, err := token.ParseJWTToken(forged, false); err == nil {
+			t.Fatal("expected error for token signed with different algorithm")
+		}
+	})
+}
+
+func TestKeyRing(t *testing.T) {
+	newKey := func(id string, status token.KeyStatus, notAfter time.Time) *token.SigningKey {
+		ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		key, err := token.ParseSigningKeyPEM(jwt.SigningMethodES256, mustPEM(t, ecKey))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		key.ID, key.Status, key.NotAfter = id, status, notAfter
+		return key
+	}
+	kidOf := func(signed string) string {
+		parsed, _, _ := new(jwt.Parser).ParseUnverified(signed, jwt.MapClaims{})
+		return parsed.Header["kid"].(string)
+	}
+
+	oldKey := newKey("old", token.KeyStatusActive, time.Time{})
+	ring, _ := token.NewKeyRing(oldKey)
+	token.SetKeyRing(ring)
+	issuedWithOld, _ := token.GenerateAccessToken("jti", time.Minute)
+
+	t.Run("Rotation keeps old tokens valid", func(t *testing.T) {
+		oldKey.Status = token.KeyStatusVerifyOnly
+		ring, err := token.NewKeyRing(oldKey, newKey("new", token.KeyStatusActive, time.Time{}))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		token.SetKeyRing(ring)
+
+		signed, _ := token.GenerateAccessToken("jti", time.Minute)
+		if kid := kidOf(signed); kid != "new" {
+			t.Fatalf("expected token signed with new key, got kid %q", kid)
+		}
+		if _, err := token.ParseJWTToken(issuedWithOld, false); err != nil {
+			t.Fatalf("expected token signed with verify-only key to be valid, got: %v", err)
+		}
+		if jwks := token.PublicJWKS(); len(jwks.Keys) != 2 {
+			t.Fatalf("expected both keys to be published, got: %+v", jwks)
+		}
+	})
+
+	t.Run("Retired key", func(t *testing.T) {
+		oldKey.Status = token.KeyStatusRetired
+		ring, _ := token.NewKeyRing(oldKey, newKey("new", token.KeyStatusActive, time.Time{}))
+		token.SetKeyRing(ring)
+
+		if _, err := token.ParseJWTToken(issuedWithOld, false); !errors.Is(err, token.ErrUnknownKey) {
+			t.Fatalf("expected ErrUnknownKey, got: %v", err)
+		}
+		if jwks := token.PublicJWKS(); len(jwks.Keys) != 1 || jwks.Keys[0].Kid != "new" {
+			t.Fatalf("expected only new key to be published, got: %+v", jwks)
+		}
+	})
+
+	t.Run("Scheduled rollover", func(t *testing.T) {
+		ring, _ := token.NewKeyRing(
+			newKey("next", token.KeyStatusActive, time.Time{}),
+			newKey("current", token.KeyStatusActive, time.Now().Add(time.Hour)),
+		)
+		token.SetKeyRing(ring)
+
+		signed, _ := token.GenerateAccessToken("jti", time.Minute)
+		if kid := kidOf(signed); kid != "current" {
+			t.Fatalf("expected key expiring first to be used, got kid %q", kid)
+		}
+		//token would outlive the current key
+		signed, _ = token.GenerateAccessToken("jti", 2*time.Hour)
+		if kid := kidOf(signed); kid != "next" {
+			t.Fatalf("expected next key to be used, got kid %q", kid)
+		}
+	})
+
+	t.Run("No active key", func(t *testing.T) {
+		if _, err := token.NewKeyRing(newKey("only", token.KeyStatusVerifyOnly, time.Time{})); !errors.Is(err, token.ErrNoActiveKey) {
+			t.Fatalf("expected ErrNoActiveKey, got: %v", err)
+		}
+	})
+}
+
+func TestGenerateIDToken(t *testing.T) {
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	key, err := token.ParseSigningKeyPEM(jwt.SigningMethodES256, mustPEM(t, ecKey))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token.SetSigningKey(key)
+	token.SetIssuer("https://auth.example.com")
+	defer token.SetIssuer("")
+
+	authTime := time.Now().Add(-time.Hour)
+	signed, err := token.GenerateIDToken(token.IDTokenClaims{
+		Subject:   "user",
+		Audience:  "spa",
+		Nonce:     "n-0S6_WzA2Mj",
+		AuthTime:  authTime,
+		SessionID: "session",
+	}, time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	parsed, err := token.ParseJWTToken(signed, false)
+	if err != nil || !parsed.Valid {
+		t.Fatalf("expected valid token, got: %v", err)
+	}
+
+	claims := parsed.Claims.(jwt.MapClaims)
+	if claims["iss"] != "https://auth.example.com" || claims["sub"] != "user" || claims["aud"] != "spa" ||
+		claims["nonce"] != "n-0S6_WzA2Mj" || claims["sid"] != "session" {
+		t.Fatalf("unexpected claims: %v", claims)
+	}
+	if int64(claims["auth_time"].(float64)) != authTime.Unix() {
+		t.Fatalf("unexpected auth_time: %v", claims["auth_time"])
+	}
+	if algs := token.SigningAlgorithms(); len(algs) != 1 || algs[0] != "ES256" {
+		t.Fatalf("unexpected signing algorithms: %v", algs)
+	}
+
+	if _, err := token.ParseAccessToken(signed, false); !errors.Is(err, token.ErrNotAccessToken) {
+		t.Fatalf("expected ID token to be rejected as access token, got: %v", err)
+	}
+	accessToken, _ := token.GenerateAccessToken("session", time.Minute)
+	if _, err := token.ParseAccessToken(accessToken, false); err != nil {
+		t.Fatalf("expected valid access token, got: %v", err)
+	}
+
+	hmacKey, _ := token.LoadSigningKey("HS512", "", "secret")
+	token.SetSigningKey(hmacKey)
+	if _, err := token.GenerateIDToken(token.IDTokenClaims{Subject: "user", Audience: "spa"}, time.Minute); !errors.Is(err, token.ErrNoAsymmetricKey) {
+		t.Fatalf("expected ErrNoAsymmetricKey, got: %v", err)
+	}
+	if token.IDTokensSupported() || len(token.SigningAlgorithms()) != 0 {
+		t.Fatalf("expected ID tokens to be unsupported with HMAC key, got algorithms %v", token.SigningAlgorithms())
+	}
+}
@@ -0,0 +1,5 @@
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS client_id;
+
+DROP TABLE IF EXISTS client_assertions;
+
+DROP TABLE IF EXISTS clients;
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS clients (
+    id                TEXT        PRIMARY KEY,
+    name              TEXT        NOT NULL DEFAULT '',
+    -- bcrypt hash of client secret, used with HTTP Basic auth
+    secret_hash       TEXT,
+    -- PEM encoded public key, verifies signed JWT client assertions
+    public_key        TEXT,
+    allow_any_user    BOOLEAN     NOT NULL DEFAULT FALSE,
+    allowed_user_ids  UUID[]      NOT NULL DEFAULT '{}',
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+-- jti of every accepted client assertion, so an assertion can't be replayed while it's valid
+CREATE TABLE IF NOT EXISTS client_assertions (
+    client_id   TEXT        NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    jti         TEXT        NOT NULL,
+    expires_at  TIMESTAMPTZ NOT NULL,
+    PRIMARY KEY (client_id, jti)
+);
+
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS client_id TEXT REFERENCES clients(id) ON DELETE SET NULL;
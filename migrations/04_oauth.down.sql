@@ -0,0 +1,6 @@
+ALTER TABLE clients DROP COLUMN IF EXISTS allowed_scopes;
+
+DROP INDEX IF EXISTS idx_refresh_tokens_lookup_hash;
+
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS scope;
+ALTER TABLE refresh_tokens DROP COLUMN IF EXISTS lookup_hash;
@@ -0,0 +1,7 @@
+-- sha256 of refresh token; unlike bcrypt hash it can be looked up, so a refresh token alone identifies its session
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS lookup_hash TEXT;
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS scope TEXT NOT NULL DEFAULT '';
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_refresh_tokens_lookup_hash ON refresh_tokens(lookup_hash);
+
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS allowed_scopes TEXT[] NOT NULL DEFAULT '{}';
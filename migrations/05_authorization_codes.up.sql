@@ -0,0 +1,19 @@
+ALTER TABLE clients ADD COLUMN IF NOT EXISTS redirect_uris TEXT[] NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS authorization_codes (
+    -- sha256 of the code, the code itself is never stored
+    code_hash              TEXT        PRIMARY KEY,
+    client_id              TEXT        NOT NULL REFERENCES clients(id) ON DELETE CASCADE,
+    user_id                UUID        NOT NULL,
+    redirect_uri           TEXT        NOT NULL,
+    scope                  TEXT        NOT NULL DEFAULT '',
+    code_challenge         TEXT        NOT NULL,
+    code_challenge_method  TEXT        NOT NULL,
+    issued_at              TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at             TIMESTAMPTZ NOT NULL,
+    used_at                TIMESTAMPTZ,
+    -- session the code was exchanged for; revoked if the code is presented again
+    session_id             UUID        REFERENCES refresh_tokens(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_authorization_codes_expires_at ON authorization_codes(expires_at);
@@ -0,0 +1,12 @@
+-- time the user authenticated at; carried over to every session rotated from the first one
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ;
+
+UPDATE refresh_tokens AS rt SET auth_time = (
+    SELECT min(f.issued_at) FROM refresh_tokens AS f WHERE f.family_id = rt.family_id
+) WHERE rt.auth_time IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN auth_time SET DEFAULT now();
+ALTER TABLE refresh_tokens ALTER COLUMN auth_time SET NOT NULL;
+
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS nonce TEXT NOT NULL DEFAULT '';
+ALTER TABLE authorization_codes ADD COLUMN IF NOT EXISTS auth_time TIMESTAMPTZ NOT NULL DEFAULT now();
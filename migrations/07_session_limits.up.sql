@@ -0,0 +1,7 @@
+-- last refresh or access token use; least recently used sessions are evicted first under evict_lru policy
+ALTER TABLE refresh_tokens ADD COLUMN IF NOT EXISTS last_used_at TIMESTAMPTZ;
+
+UPDATE refresh_tokens SET last_used_at = issued_at WHERE last_used_at IS NULL;
+
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET DEFAULT now();
+ALTER TABLE refresh_tokens ALTER COLUMN last_used_at SET NOT NULL;
@@ -0,0 +1,3 @@
+-- expired sessions and assertions are purged in batches by expires_at
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_expires_at ON refresh_tokens(expires_at);
+CREATE INDEX IF NOT EXISTS idx_client_assertions_expires_at ON client_assertions(expires_at);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS webhook_outbox (
+    id               UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    event            TEXT        NOT NULL,
+    payload          JSONB       NOT NULL,
+    -- traceparent of the request that produced the message
+    trace_context    JSONB       NOT NULL DEFAULT '{}',
+    -- pending, delivered or dead
+    status           TEXT        NOT NULL DEFAULT 'pending',
+    attempts         INT         NOT NULL DEFAULT 0,
+    -- pending message is delivered once it's due; it's pushed forward while the message is being delivered
+    next_attempt_at  TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error       TEXT        NOT NULL DEFAULT '',
+    created_at       TIMESTAMPTZ NOT NULL DEFAULT now(),
+    delivered_at     TIMESTAMPTZ
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_due ON webhook_outbox(next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_delivered_at ON webhook_outbox(delivered_at) WHERE status = 'delivered';
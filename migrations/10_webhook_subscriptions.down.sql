@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_webhook_outbox_subscription_id;
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS event_id, DROP COLUMN IF EXISTS subscription_id;
+DROP TABLE IF EXISTS webhook_subscriptions;
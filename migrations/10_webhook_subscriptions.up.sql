@@ -0,0 +1,30 @@
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    id                UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    name              TEXT        NOT NULL DEFAULT '',
+    url               TEXT        NOT NULL,
+    -- HMAC key webhooks are signed with; kept as is, as it's needed to sign
+    secret            TEXT        NOT NULL,
+    -- events the subscription receives, every event if empty
+    event_types       TEXT[]      NOT NULL DEFAULT '{}',
+    enabled           BOOLEAN     NOT NULL DEFAULT true,
+    created_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at        TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- delivery statistics, updated along with outbox messages
+    delivered_count   BIGINT      NOT NULL DEFAULT 0,
+    failed_attempts   BIGINT      NOT NULL DEFAULT 0,
+    dead_count        BIGINT      NOT NULL DEFAULT 0,
+    last_delivered_at TIMESTAMPTZ,
+    last_failed_at    TIMESTAMPTZ,
+    last_error        TEXT        NOT NULL DEFAULT ''
+);
+
+-- webhooks written for WEBHOOK_URL are left pending without a subscription; the service attaches them
+-- to the subscription it creates from WEBHOOK_URL and WEBHOOK_SECRET on startup
+ALTER TABLE webhook_outbox
+    ADD COLUMN IF NOT EXISTS subscription_id UUID REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    -- the same for every subscription an event is written for
+    ADD COLUMN IF NOT EXISTS event_id UUID;
+UPDATE webhook_outbox SET event_id = id WHERE event_id IS NULL;
+ALTER TABLE webhook_outbox ALTER COLUMN event_id SET NOT NULL;
+
+CREATE INDEX IF NOT EXISTS idx_webhook_outbox_subscription_id ON webhook_outbox(subscription_id);
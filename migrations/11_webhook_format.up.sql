@@ -0,0 +1,2 @@
+-- legacy, cloudevents_structured or cloudevents_binary
+ALTER TABLE webhook_subscriptions ADD COLUMN IF NOT EXISTS format TEXT NOT NULL DEFAULT 'legacy';
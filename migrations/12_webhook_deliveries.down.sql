@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS webhook_deliveries;
+ALTER TABLE webhook_outbox DROP COLUMN IF EXISTS user_id;
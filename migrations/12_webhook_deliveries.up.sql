@@ -0,0 +1,34 @@
+-- user the event is about, so deliveries may be looked up by user
+ALTER TABLE webhook_outbox ADD COLUMN IF NOT EXISTS user_id UUID NOT NULL DEFAULT '00000000-0000-0000-0000-000000000000';
+UPDATE webhook_outbox SET user_id = (payload->>'user_id')::uuid WHERE payload ? 'user_id';
+ALTER TABLE webhook_outbox ALTER COLUMN user_id DROP DEFAULT;
+
+-- every attempt to deliver an outbox message
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id              UUID        PRIMARY KEY DEFAULT gen_random_uuid(),
+    -- no foreign key: delivered messages are purged before their deliveries
+    outbox_id       UUID        NOT NULL,
+    subscription_id UUID        NOT NULL REFERENCES webhook_subscriptions(id) ON DELETE CASCADE,
+    event_id        UUID        NOT NULL,
+    event           TEXT        NOT NULL,
+    user_id         UUID        NOT NULL,
+    -- 1 for the first attempt of the message
+    attempt         INT         NOT NULL,
+    -- succeeded or failed
+    status          TEXT        NOT NULL,
+    request_url     TEXT        NOT NULL,
+    request_headers JSONB       NOT NULL DEFAULT '{}',
+    request_body    TEXT        NOT NULL,
+    -- NULL if no response was received
+    response_status INT,
+    -- truncated
+    response_body   TEXT        NOT NULL DEFAULT '',
+    latency_ms      INT         NOT NULL,
+    error           TEXT        NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_created_at ON webhook_deliveries(created_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_user_id ON webhook_deliveries(user_id, created_at);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_event_id ON webhook_deliveries(event_id);
+CREATE INDEX IF NOT EXISTS idx_webhook_deliveries_subscription_id ON webhook_deliveries(subscription_id, created_at);
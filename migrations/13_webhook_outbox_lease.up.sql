@@ -0,0 +1,3 @@
+-- end of the lease of a message claimed by a dispatcher, NULL once it's marked; a leased message isn't replayed,
+-- as it's being delivered already
+ALTER TABLE webhook_outbox ADD COLUMN IF NOT EXISTS leased_until TIMESTAMPTZ;
@@ -0,0 +1,31 @@
+// Package migrations embeds SQL migrations, so the service knows the schema version it's built for
+package migrations
+
+import (
+	"embed"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+//go:embed *.sql
+var files embed.FS
+
+// returns version of the latest migration, i.e. the numeric prefix of its file name
+func LatestVersion() uint {
+	entries, _ := fs.ReadDir(files, ".")
+	var latest uint
+	for _, entry := range entries {
+		prefix, _, ok := strings.Cut(entry.Name(), "_")
+		if !ok {
+			continue
+		}
+		version, err := strconv.ParseUint(prefix, 10, 64)
+		if err != nil {
+			continue
+		}
+		latest = max(latest, uint(version))
+	}
+
+	return latest
+}
@@ -0,0 +1,48 @@
+package webhook
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// ReplayCache of a single receiver instance
+type MemoryCache struct {
+	mu        sync.Mutex
+	seen      map[string]time.Time
+	nextSweep time.Time
+}
+
+func NewMemoryCache() *MemoryCache {
+	return &MemoryCache{seen: map[string]time.Time{}}
+}
+
+func (mc *MemoryCache) Contains(ctx context.Context, id string) (bool, error) {
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+	expiresAt, ok := mc.seen[id]
+
+	return ok && time.Now().Before(expiresAt), nil
+}
+
+func (mc *MemoryCache) Add(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	now := time.Now()
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	//expired IDs are dropped once per ttl, so the map holds at most two ttl worth of them
+	if now.After(mc.nextSweep) {
+		for seenID, expiresAt := range mc.seen {
+			if now.After(expiresAt) {
+				delete(mc.seen, seenID)
+			}
+		}
+		mc.nextSweep = now.Add(ttl)
+	}
+	if expiresAt, ok := mc.seen[id]; ok && now.Before(expiresAt) {
+		return false, nil
+	}
+	mc.seen[id] = now.Add(ttl)
+
+	return true, nil
+}
@@ -0,0 +1,206 @@
+// Package webhook signs webhooks of the auth service and verifies them on the receiving side.
+//
+// Every webhook carries three headers:
+//
+//	X-Webhook-Id:        <uuid of the event, the same for every retry of it>
+//	X-Webhook-Timestamp: <unix seconds the delivery attempt was signed at>
+//	X-Signature:         sha256=<hex HMAC-SHA256 of "<id>.<timestamp>.<body>" keyed with the endpoint secret>
+//
+// A receiver rejects a webhook whose signature doesn't match, whose timestamp is off by more than
+// the tolerance, or whose ID has already been processed. The ID is recorded with MarkProcessed once
+// the webhook is handled, so a delivery that failed to be handled is handled again when it's retried:
+//
+//	verifier := webhook.NewVerifier([][]byte{secret}, 0, nil)
+//	http.HandleFunc("/webhooks", func(w http.ResponseWriter, r *http.Request) {
+//		body, err := verifier.VerifyRequest(r)
+//		if errors.Is(err, webhook.ErrReplayed) {
+//			//already handled; 2xx stops retries of the event
+//			w.WriteHeader(http.StatusOK)
+//			return
+//		}
+//		if err != nil {
+//			w.WriteHeader(http.StatusUnauthorized)
+//			return
+//		}
+//		if err := handle(body); err != nil {
+//			//not marked, the retry is handled again
+//			w.WriteHeader(http.StatusInternalServerError)
+//			return
+//		}
+//		verifier.MarkProcessed(r.Context(), r.Header.Get(webhook.HeaderID))
+//		w.WriteHeader(http.StatusOK)
+//	})
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	HeaderID        = "X-Webhook-Id"
+	HeaderTimestamp = "X-Webhook-Timestamp"
+	HeaderSignature = "X-Signature"
+
+	signaturePrefix = "sha256="
+	//default maximum difference between the timestamp of a webhook and the receiver's clock
+	DefaultTolerance = 5 * time.Minute
+	//default time IDs of processed webhooks are kept for; it covers the service's default retry schedule
+	//(10 attempts over about an hour and a half), raise it along with WEBHOOK_RETRY_* settings
+	DefaultReplayWindow = 24 * time.Hour
+	//webhook bodies are read up to that many bytes by VerifyRequest
+	MaxBodySize = 1 << 20
+)
+
+var (
+	ErrMissingHeader    = errors.New("webhook: missing signature headers")
+	ErrInvalidTimestamp = errors.New("webhook: invalid timestamp")
+	ErrTimestampExpired = errors.New("webhook: timestamp out of tolerance")
+	ErrInvalidSignature = errors.New("webhook: signature mismatch")
+	ErrReplayed         = errors.New("webhook: event already received")
+	ErrBodyTooLarge     = errors.New("webhook: body too large")
+)
+
+// returns X-Signature header value of a webhook
+func Sign(secret []byte, id string, timestamp time.Time, body []byte) string {
+	return signaturePrefix + hex.EncodeToString(mac(secret, id, strconv.FormatInt(timestamp.Unix(), 10), body))
+}
+
+// sets ID, timestamp and signature headers of a webhook request
+func SignRequest(req *http.Request, secret []byte, id string, timestamp time.Time, body []byte) {
+	req.Header.Set(HeaderID, id)
+	req.Header.Set(HeaderTimestamp, strconv.FormatInt(timestamp.Unix(), 10))
+	req.Header.Set(HeaderSignature, Sign(secret, id, timestamp, body))
+}
+
+func mac(secret []byte, id, timestamp string, body []byte) []byte {
+	h := hmac.New(sha256.New, secret)
+	h.Write([]byte(id))
+	h.Write([]byte("."))
+	h.Write([]byte(timestamp))
+	h.Write([]byte("."))
+	h.Write(body)
+
+	return h.Sum(nil)
+}
+
+// remembers IDs of processed webhooks; implement it over a shared storage if several instances receive webhooks
+type ReplayCache interface {
+	//reports whether id is recorded
+	Contains(ctx context.Context, id string) (bool, error)
+	//records id for ttl; returns false if it's already recorded
+	Add(ctx context.Context, id string, ttl time.Duration) (bool, error)
+}
+
+type Verifier struct {
+	secrets      [][]byte
+	tolerance    time.Duration
+	cache        ReplayCache
+	replayWindow time.Duration
+}
+
+// secrets are tried in order, so the old and the new one may be accepted while the secret is rotated;
+// DefaultTolerance is used if tolerance is 0, an in-memory cache if cache is nil
+func NewVerifier(secrets [][]byte, tolerance time.Duration, cache ReplayCache) *Verifier {
+	if len(secrets) == 0 {
+		panic("webhook: no secrets")
+	}
+	if tolerance <= 0 {
+		tolerance = DefaultTolerance
+	}
+	if cache == nil {
+		cache = NewMemoryCache()
+	}
+
+	return &Verifier{secrets: secrets, tolerance: tolerance, cache: cache, replayWindow: DefaultReplayWindow}
+}
+
+// sets how long IDs of processed webhooks are kept; it must be longer than the service keeps retrying an event
+func (v *Verifier) WithReplayWindow(window time.Duration) *Verifier {
+	v.replayWindow = window
+	return v
+}
+
+// checks signature headers of a webhook with the given body; ErrReplayed is returned for an event marked processed before,
+// an identical request replayed or a retry of a delivery the receiver answered but the service didn't get the answer of.
+// The ID isn't recorded, call MarkProcessed once the webhook is handled
+func (v *Verifier) Verify(ctx context.Context, header http.Header, body []byte) error {
+	id, rawTimestamp, signature := header.Get(HeaderID), header.Get(HeaderTimestamp), header.Get(HeaderSignature)
+	if id == "" || rawTimestamp == "" || signature == "" {
+		return ErrMissingHeader
+	}
+	unix, err := strconv.ParseInt(rawTimestamp, 10, 64)
+	if err != nil {
+		return ErrInvalidTimestamp
+	}
+	skew := time.Since(time.Unix(unix, 0))
+	if skew > v.tolerance || skew < -v.tolerance {
+		return ErrTimestampExpired
+	}
+
+	sum, err := hex.DecodeString(strings.TrimPrefix(signature, signaturePrefix))
+	if err != nil || !strings.HasPrefix(signature, signaturePrefix) {
+		return ErrInvalidSignature
+	}
+	if !v.matches(id, rawTimestamp, body, sum) {
+		return ErrInvalidSignature
+	}
+
+	seen, err := v.cache.Contains(ctx, id)
+	if err != nil {
+		return fmt.Errorf("webhook: replay cache: %w", err)
+	}
+	if seen {
+		return ErrReplayed
+	}
+
+	return nil
+}
+
+// records id of a handled webhook, so its retries and replays are rejected with ErrReplayed for the replay window;
+// ErrReplayed is returned if it's been recorded already, e.g. by a concurrent delivery of the same event
+func (v *Verifier) MarkProcessed(ctx context.Context, id string) error {
+	added, err := v.cache.Add(ctx, id, v.replayWindow)
+	if err != nil {
+		return fmt.Errorf("webhook: replay cache: %w", err)
+	}
+	if !added {
+		return ErrReplayed
+	}
+
+	return nil
+}
+
+func (v *Verifier) matches(id, timestamp string, body, sum []byte) bool {
+	for _, secret := range v.secrets {
+		if hmac.Equal(mac(secret, id, timestamp, body), sum) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// reads body of a webhook request and verifies it; body is returned and left readable in r
+func (v *Verifier) VerifyRequest(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(io.LimitReader(r.Body, MaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > MaxBodySize {
+		return nil, ErrBodyTooLarge
+	}
+	r.Body = io.NopCloser(bytes.NewReader(body))
+
+	return body, v.Verify(r.Context(), r.Header, body)
+}
@@ -0,0 +1,152 @@
+package webhook_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/superdumb33/auth-service-test/pkg/webhook"
+)
+
+func signedRequest(t *testing.T, secret []byte, id string, timestamp time.Time, body []byte) *http.Request {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://receiver/webhooks", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	webhook.SignRequest(req, secret, id, timestamp, body)
+	return req
+}
+
+func TestVerifier_VerifyRequest(t *testing.T) {
+	secret := []byte("endpoint-secret")
+	body := []byte(`{"event":"ip_change","user_id":"42"}`)
+	ctx := context.Background()
+
+	t.Run("Valid", func(t *testing.T) {
+		verifier := webhook.NewVerifier([][]byte{secret}, 0, nil)
+		got, err := verifier.VerifyRequest(signedRequest(t, secret, "evt-1", time.Now(), body))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !bytes.Equal(got, body) {
+			t.Fatalf("expected body %s, got %s", body, got)
+		}
+	})
+
+	t.Run("Rotated secret", func(t *testing.T) {
+		verifier := webhook.NewVerifier([][]byte{[]byte("new-secret"), secret}, 0, nil)
+		if _, err := verifier.VerifyRequest(signedRequest(t, secret, "evt-1", time.Now(), body)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	})
+
+	t.Run("Forged", func(t *testing.T) {
+		verifier := webhook.NewVerifier([][]byte{secret}, 0, nil)
+		cases := map[string]*http.Request{
+			"wrong secret": signedRequest(t, []byte("guess"), "evt-1", time.Now(), body),
+			"tampered body": func() *http.Request {
+				req := signedRequest(t, secret, "evt-1", time.Now(), body)
+				req.Body = io.NopCloser(strings.NewReader(`{"event":"ip_change","user_id":"43"}`))
+				return req
+			}(),
+			"swapped ID": func() *http.Request {
+				req := signedRequest(t, secret, "evt-1", time.Now(), body)
+				req.Header.Set(webhook.HeaderID, "evt-2")
+				return req
+			}(),
+			"moved timestamp": func() *http.Request {
+				req := signedRequest(t, secret, "evt-1", time.Now(), body)
+				req.Header.Set(webhook.HeaderTimestamp, strconv.FormatInt(time.Now().Unix()+1, 10))
+				return req
+			}(),
+			"malformed signature": func() *http.Request {
+				req := signedRequest(t, secret, "evt-1", time.Now(), body)
+				req.Header.Set(webhook.HeaderSignature, "sha256=zz")
+				return req
+			}(),
+		}
+		for name, req := range cases {
+			if _, err := verifier.VerifyRequest(req); !errors.Is(err, webhook.ErrInvalidSignature) {
+				t.Fatalf("%s: expected ErrInvalidSignature, got %v", name, err)
+			}
+		}
+	})
+
+	t.Run("Missing headers", func(t *testing.T) {
+		verifier := webhook.NewVerifier([][]byte{secret}, 0, nil)
+		req := signedRequest(t, secret, "evt-1", time.Now(), body)
+		req.Header.Del(webhook.HeaderSignature)
+		if _, err := verifier.VerifyRequest(req); !errors.Is(err, webhook.ErrMissingHeader) {
+			t.Fatalf("expected ErrMissingHeader, got %v", err)
+		}
+	})
+
+	t.Run("Stale timestamp", func(t *testing.T) {
+		verifier := webhook.NewVerifier([][]byte{secret}, time.Minute, nil)
+		for _, ts := range []time.Time{time.Now().Add(-2 * time.Minute), time.Now().Add(2 * time.Minute)} {
+			if _, err := verifier.VerifyRequest(signedRequest(t, secret, "evt-1", ts, body)); !errors.Is(err, webhook.ErrTimestampExpired) {
+				t.Fatalf("expected ErrTimestampExpired, got %v", err)
+			}
+		}
+	})
+
+	t.Run("Replayed", func(t *testing.T) {
+		verifier := webhook.NewVerifier([][]byte{secret}, 0, nil)
+		req := signedRequest(t, secret, "evt-1", time.Now(), body)
+		if err := verifier.Verify(ctx, req.Header, body); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		//handling failed, so the ID isn't marked and the retry is accepted
+		retry := signedRequest(t, secret, "evt-1", time.Now().Add(time.Second), body)
+		if err := verifier.Verify(ctx, retry.Header, body); err != nil {
+			t.Fatalf("expected retry of unprocessed event to be accepted, got %v", err)
+		}
+		if err := verifier.MarkProcessed(ctx, "evt-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if err := verifier.Verify(ctx, req.Header, body); !errors.Is(err, webhook.ErrReplayed) {
+			t.Fatalf("expected ErrReplayed, got %v", err)
+		}
+		//a retry of the same event is signed anew, but it's still a duplicate
+		retry = signedRequest(t, secret, "evt-1", time.Now().Add(2*time.Second), body)
+		if err := verifier.Verify(ctx, retry.Header, body); !errors.Is(err, webhook.ErrReplayed) {
+			t.Fatalf("expected ErrReplayed, got %v", err)
+		}
+		if err := verifier.MarkProcessed(ctx, "evt-1"); !errors.Is(err, webhook.ErrReplayed) {
+			t.Fatalf("expected ErrReplayed for the second mark, got %v", err)
+		}
+	})
+
+	t.Run("Replay window", func(t *testing.T) {
+		cache := &recordingCache{MemoryCache: webhook.NewMemoryCache()}
+		verifier := webhook.NewVerifier([][]byte{secret}, 0, cache)
+		if err := verifier.MarkProcessed(ctx, "evt-1"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		//the service retries an event for up to about an hour and a half
+		if cache.ttl != webhook.DefaultReplayWindow || cache.ttl < 2*time.Hour {
+			t.Fatalf("expected ID to be kept for the default replay window, got %v", cache.ttl)
+		}
+		verifier.WithReplayWindow(72*time.Hour).MarkProcessed(ctx, "evt-2")
+		if cache.ttl != 72*time.Hour {
+			t.Fatalf("expected ID to be kept for 72h, got %v", cache.ttl)
+		}
+	})
+}
+
+type recordingCache struct {
+	*webhook.MemoryCache
+	ttl time.Duration
+}
+
+func (rc *recordingCache) Add(ctx context.Context, id string, ttl time.Duration) (bool, error) {
+	rc.ttl = ttl
+	return rc.MemoryCache.Add(ctx, id, ttl)
+}